@@ -3,28 +3,15 @@
 package config
 
 import (
-	"os"
-
 	"github.com/mitchellh/go-homedir"
 )
 
 const bundleIdentifier = "com.github.bencase.revis"
 
-func init() {
+// On macOS everything lives in the app's sandboxed container.
+func getDefaultDirs() (string, string, error) {
 	dir, err := homedir.Dir()
-	if err != nil {
-		logger.Error("Error trying to find home directory:", err.Error())
-		return
-	}
-	libraryPathWithoutTrailingSlash := dir + "/Library/Containers/" + bundleIdentifier + "/Data/Documents"
-	LibraryPath = libraryPathWithoutTrailingSlash + "/"
-	// If this directory doesn't exist, create it
-	_, err = os.Stat(libraryPathWithoutTrailingSlash)
-	if os.IsNotExist(err) {
-		err = os.MkdirAll(libraryPathWithoutTrailingSlash, os.ModePerm)
-		if err != nil {
-			logger.Error("Error creating directory path:", err.Error())
-			return
-		}
-	}
+	if err != nil { return "", "", err }
+	libraryPath := dir + "/Library/Containers/" + bundleIdentifier + "/Data/Documents"
+	return libraryPath, libraryPath, nil
 }
\ No newline at end of file
@@ -1,9 +1,123 @@
 package config
 
 import (
+	"io"
+	"os"
+	"path/filepath"
+
 	glogging "github.com/op/go-logging"
 )
 
+// The directory holding conns.yml
 var LibraryPath = ""
+// The directory holding the encryption key and other data files
+var DataPath = ""
+
+// Overrides both directories when set and no directory is given on the command line
+const DataDirEnvVar = "REVIS_DATA_DIR"
+
+const appDirName = "revis"
+
+const ConnectionsFilename = "conns.yml"
+const KeyFilename = "key.txt"
+const MasterKeyFilename = "master-key.yml"
+const AdminTokenFilename = "admin-token.txt"
+
+var logger = glogging.MustGetLogger("config")
+
+// Sets LibraryPath and DataPath, creating the directories if needed. A blank
+// dataDir falls back to the environment variable and then the OS defaults.
+// Files left in the working directory by earlier versions are moved over.
+func InitPaths(dataDir string) error {
+	if dataDir == "" {
+		dataDir = os.Getenv(DataDirEnvVar)
+	}
+	configDir := dataDir
+	if dataDir == "" {
+		var err error
+		configDir, dataDir, err = getDefaultDirs()
+		if err != nil { return err }
+	}
+	for _, dir := range []string{configDir, dataDir} {
+		if dir == "" {
+			continue
+		}
+		err := os.MkdirAll(dir, 0700)
+		if err != nil { return err }
+	}
+	LibraryPath = withTrailingSeparator(configDir)
+	DataPath = withTrailingSeparator(dataDir)
+	logger.Info("Storing connections in", displayPath(LibraryPath), "and keys in", displayPath(DataPath))
+
+	migrateFromWorkingDir(ConnectionsFilename, LibraryPath)
+	migrateFromWorkingDir(KeyFilename, DataPath)
+	return nil
+}
+
+// Paths are kept with a trailing separator so that file names can be appended,
+// or blank for the working directory.
+func withTrailingSeparator(dir string) string {
+	if dir == "" {
+		return ""
+	}
+	return filepath.Clean(dir) + string(filepath.Separator)
+}
+func displayPath(dir string) string {
+	if dir == "" {
+		return "the working directory"
+	}
+	return dir
+}
 
-var logger = glogging.MustGetLogger("config")
\ No newline at end of file
+// Moves a file from the working directory into the new directory, unless the
+// new directory already has one.
+func migrateFromWorkingDir(filename string, dir string) {
+	if dir == "" {
+		return
+	}
+	oldPath, err := filepath.Abs(filename)
+	if err != nil { return }
+	newPath, err := filepath.Abs(dir + filename)
+	if err != nil || oldPath == newPath {
+		return
+	}
+	if _, err = os.Stat(oldPath); err != nil {
+		return
+	}
+	if _, err = os.Stat(newPath); !os.IsNotExist(err) {
+		logger.Warning("Not migrating", oldPath, "since", newPath, "already exists")
+		return
+	}
+	err = moveFile(oldPath, newPath)
+	if err != nil {
+		logger.Error("Error migrating", oldPath, "to", newPath + ":", err)
+		return
+	}
+	logger.Info("Migrated", oldPath, "to", newPath)
+}
+// Renames the file, falling back to copying it when the rename crosses filesystems.
+func moveFile(oldPath string, newPath string) error {
+	if err := os.Rename(oldPath, newPath); err == nil {
+		return nil
+	}
+	src, err := os.Open(oldPath)
+	if err != nil { return err }
+	defer src.Close()
+	info, err := src.Stat()
+	if err != nil { return err }
+	dst, err := os.OpenFile(newPath, os.O_WRONLY|os.O_CREATE|os.O_EXCL, info.Mode().Perm())
+	if err != nil { return err }
+	_, err = io.Copy(dst, src)
+	if err == nil {
+		err = dst.Sync()
+	}
+	if closeErr := dst.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(newPath)
+		return err
+	}
+	src.Close()
+	return os.Remove(oldPath)
+}
\ No newline at end of file
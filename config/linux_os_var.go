@@ -0,0 +1,25 @@
+// +build linux
+
+package config
+
+import (
+	"os"
+	"path/filepath"
+
+	"github.com/mitchellh/go-homedir"
+)
+
+// Connections go in the XDG config directory and keys in the XDG data directory.
+func getDefaultDirs() (string, string, error) {
+	dir, err := homedir.Dir()
+	if err != nil { return "", "", err }
+	configHome := os.Getenv("XDG_CONFIG_HOME")
+	if configHome == "" {
+		configHome = filepath.Join(dir, ".config")
+	}
+	dataHome := os.Getenv("XDG_DATA_HOME")
+	if dataHome == "" {
+		dataHome = filepath.Join(dir, ".local", "share")
+	}
+	return filepath.Join(configHome, appDirName), filepath.Join(dataHome, appDirName), nil
+}
\ No newline at end of file
@@ -0,0 +1,8 @@
+// +build !darwin,!linux,!windows
+
+package config
+
+// Other systems keep using the working directory.
+func getDefaultDirs() (string, string, error) {
+	return "", "", nil
+}
\ No newline at end of file
@@ -0,0 +1,18 @@
+// +build windows
+
+package config
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+)
+
+func getDefaultDirs() (string, string, error) {
+	appData := os.Getenv("APPDATA")
+	if appData == "" {
+		return "", "", errors.New("The APPDATA environment variable is not set")
+	}
+	dir := filepath.Join(appData, appDirName)
+	return dir, dir, nil
+}
\ No newline at end of file
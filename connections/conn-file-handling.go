@@ -13,42 +13,118 @@ import (
 	"github.com/bencase/revis-service/connections/encrypt"
 )
 
-const filename = "conns.yml"
+const filename = config.ConnectionsFilename
 
 var ConnectionNotFoundError = errors.New("Could not find connection with that name")
+var StoreLockedError = encrypt.StoreLockedError
+var WrongMasterPasswordError = encrypt.WrongMasterPasswordError
+
+// Prepares the encryption key, generating one on first run, and re-encrypts
+// any secrets that are still sealed with the legacy built-in key.
+func InitStore() error {
+	err := recoverInterruptedKeyRotation()
+	if err != nil { return err }
+	_, err = encrypt.InitKey()
+	if err != nil { return err }
+	// A store protected by a master password starts out locked, and never
+	// used the legacy key
+	if encrypt.MasterPasswordEnabled() {
+		return nil
+	}
+	return reencryptLegacySecrets()
+}
+func reencryptLegacySecrets() error {
+	unlock, err := acquireStoreLock()
+	if err != nil { return err }
+	defer unlock()
+	conns, err := readConnectionsNoDecrypt()
+	if err != nil { return err }
+	reencryptedCount := 0
+	for _, conn := range conns {
+		for _, secret := range getSecretsOfConn(conn) {
+			if *secret == "" {
+				continue
+			}
+			if _, err = encrypt.DecryptFromBase64(*secret); err == nil {
+				continue
+			}
+			decryptedSecret, err := encrypt.DecryptLegacyFromBase64(*secret)
+			if err != nil {
+				logger.Error("Could not decrypt a secret of connection",
+					GetEffectiveNameOfConn(conn), "with either the current or the legacy key")
+				continue
+			}
+			*secret, err = encrypt.EncryptToBase64(decryptedSecret)
+			if err != nil { return err }
+			reencryptedCount++
+		}
+	}
+	if reencryptedCount == 0 {
+		return nil
+	}
+	err = writeConnections(conns)
+	if err != nil { return err }
+	logger.Info("Re-encrypted", reencryptedCount, "secrets that used the legacy key")
+	return nil
+}
 
 func readConnectionsNoDecrypt() ([]*dto.Connection, error) {
+	conns, _, err := readConnectionsFile()
+	return conns, err
+}
+// Returns the connections as stored, along with the revision of the file.
+func readConnectionsFile() ([]*dto.Connection, string, error) {
 	// If the file doesn't exist, return an empty list
 	if fileDoesntExist() {
-		return []*dto.Connection{}, nil
+		return []*dto.Connection{}, getRevision(nil), nil
 	}
 	
 	// Get current contents of connections file
-	inBytes, err := ioutil.ReadFile(config.LibraryPath + filename)
-	if err != nil { return []*dto.Connection{}, err }
+	inBytes, err := ioutil.ReadFile(getConnsPath())
+	if err != nil { return []*dto.Connection{}, "", err }
 	conns := make([]*dto.Connection, 0)
 	err = yaml.Unmarshal(inBytes, &conns)
-	return conns, err
+	return conns, getRevision(inBytes), err
 }
 func ReadConnections() ([]*dto.Connection, error) {
-	conns, err := readConnectionsNoDecrypt()
-	if err != nil { return []*dto.Connection{}, err }
+	conns, _, err := ReadConnectionsWithRevision()
+	return conns, err
+}
+// Reads the connections along with the revision that edits must be based on.
+func ReadConnectionsWithRevision() ([]*dto.Connection, string, error) {
+	conns, revision, err := readConnectionsFile()
+	if err != nil { return []*dto.Connection{}, "", err }
 
-	// Decrypt passwords
+	// While the store is locked the secrets can't be decrypted, so they're left out
+	if encrypt.IsLocked() {
+		for _, conn := range conns {
+			for _, secret := range getSecretsOfConn(conn) {
+				*secret = ""
+			}
+		}
+		return conns, revision, nil
+	}
+
+	// Decrypt passwords and other secrets
 	for _, conn := range conns {
-		if conn.Password != "" {
-			decryptedPwd, err := encrypt.DecryptFromBase64(conn.Password)
-			if err != nil { return []*dto.Connection{}, err }
-			conn.Password = decryptedPwd
+		for _, secret := range getSecretsOfConn(conn) {
+			if *secret != "" {
+				decryptedSecret, err := encrypt.DecryptFromBase64(*secret)
+				if err != nil { return []*dto.Connection{}, "", err }
+				*secret = decryptedSecret
+			}
 		}
 	}
-	return conns, err
+	return conns, revision, err
 }
 
 // Gets the connection that has the provided name, looking instead at
 // combined host and port on connections that have no name. If no such
 // connection exists, returns nil without error.
 func GetConnectionWithName(name string) (*dto.Connection, error) {
+	if encrypt.IsLocked() {
+		return nil, StoreLockedError
+	}
 	conns, err := ReadConnections()
 	if err != nil {
 		return nil, err
@@ -61,25 +137,41 @@ func GetConnectionWithName(name string) (*dto.Connection, error) {
 	return nil, nil
 }
 
-func UpsertConnections(reqObj *dto.UpsertConnectionsRequest) error {
+// Upserts the connections and returns the new revision. If expectedRevision
+// isn't blank and the connections have changed since that revision, nothing
+// is written and RevisionMismatchError is returned.
+func UpsertConnections(reqObj *dto.UpsertConnectionsRequest, expectedRevision string) (string, error) {
+	// Connections read while locked have no secrets, so saving them back would erase the secrets
+	if encrypt.IsLocked() {
+		return "", StoreLockedError
+	}
+	unlock, err := acquireStoreLock()
+	if err != nil { return "", err }
+	defer unlock()
 	// Get current contents of connections file
-	conns, err := readConnectionsNoDecrypt()
-	if err != nil { return err }
+	conns, revision, err := readConnectionsFile()
+	if err != nil { return "", err }
+	err = checkRevision(expectedRevision, revision)
+	if err != nil { return "", err }
+	err = validateUpserts(reqObj)
+	if err != nil { return "", err }
 	// Upsert connections
 	for _, connUpsert := range reqObj.Connections {
 		newConn := connUpsert.NewConn
 		replace := connUpsert.OldConnName
 		err = upsertConnection(newConn, replace, &conns)
-		if err != nil { return err }
+		if err != nil { return "", err }
 	}
+	err = checkNameCollisions(reqObj, conns)
+	if err != nil { return "", err }
 	// Rewrite all connections
-	err = writeConnections(conns)
+	newRevision, err := writeConnectionsWithRevision(conns)
 	if err == nil {
 		logger.Info("Upserted connections to file")
 	} else {
 		logger.Info("Error when attempting to upsert connections to file")
 	}
-	return err
+	return newRevision, err
 }
 func upsertConnection(newConn *dto.Connection, replace string, pConns *[]*dto.Connection) error {
 	encryptedNewConn, err := getConnWithPasswordEncrypted(newConn)
@@ -115,25 +207,35 @@ func getConnsWithPasswordsEncrypted(origConns []*dto.Connection) ([]*dto.Connect
 	return encryptedConns, nil
 }
 func getConnWithPasswordEncrypted(conn *dto.Connection) (*dto.Connection, error) {
-	encryptedConn := *conn
-	if encryptedConn.Password != "" {
-		encryptedPwd, err := encrypt.EncryptToBase64(encryptedConn.Password)
-		if err != nil { return nil, err }
-		encryptedConn.Password = encryptedPwd
+	// Copy the connection so that the caller's keeps its plaintext secrets
+	encryptedConn := copyConn(conn)
+	for _, secret := range getSecretsOfConn(encryptedConn) {
+		if *secret != "" {
+			encryptedSecret, err := encrypt.EncryptToBase64(*secret)
+			if err != nil { return nil, err }
+			*secret = encryptedSecret
+		}
 	}
-	return &encryptedConn, nil
+	return encryptedConn, nil
 }
 
-func DeleteConnections(connNames []string) error {
+// Deletes the connections and returns the new revision, checking
+// expectedRevision as UpsertConnections does.
+func DeleteConnections(connNames []string, expectedRevision string) (string, error) {
+	unlock, err := acquireStoreLock()
+	if err != nil { return "", err }
+	defer unlock()
+	
+	// Get current contents of connections file
+	prevConns, revision, err := readConnectionsFile()
+	if err != nil { return "", err }
+	err = checkRevision(expectedRevision, revision)
+	if err != nil { return "", err }
 	// If file doesn't exist, there's nothing to delete
 	if fileDoesntExist() {
-		return nil
+		return revision, nil
 	}
 	
-	// Get current contents of connections file
-	prevConns, err := readConnectionsNoDecrypt()
-	if err != nil { return err }
-	
 	// Create a new slice of connections, with any connections having a name
 	// in the provided string slice removed
 	allConns := make([]*dto.Connection, 0)
@@ -144,28 +246,35 @@ func DeleteConnections(connNames []string) error {
 	}
 	
 	// Re-write the file
-	err = writeConnections(allConns)
+	newRevision, err := writeConnectionsWithRevision(allConns)
 	if err == nil {
 		logger.Info("Deleted connections from file")
 	} else {
 		logger.Info("Error when attempting to delete connections from file")
 	}
-	return err
+	return newRevision, err
 }
 
+func getConnsPath() string {
+	return config.LibraryPath + filename
+}
 func fileDoesntExist() bool {
-	_, err := os.Stat(config.LibraryPath + filename)
+	_, err := os.Stat(getConnsPath())
 	return os.IsNotExist(err)
 }
 
+// Replaces the connections file atomically, keeping the previous contents as
+// a version that can be restored. Callers must hold the store lock.
 func writeConnections(conns []*dto.Connection) error {
-	// Create (or truncate if already exists) the file
-	file, err := os.Create(config.LibraryPath + filename)
-	if err != nil { return err }
-	
-	// Write to the file
-	outBytes, err := yaml.Marshal(conns)
-	if err != nil { return err }
-	_, err = file.Write(outBytes)
+	_, err := writeConnectionsWithRevision(conns)
 	return err
+}
+func writeConnectionsWithRevision(conns []*dto.Connection) (string, error) {
+	outBytes, err := yaml.Marshal(conns)
+	if err != nil { return "", err }
+	err = saveConnectionsVersion()
+	if err != nil { return "", err }
+	err = util.WriteFileAtomic(getConnsPath(), outBytes, 0600)
+	if err != nil { return "", err }
+	return getRevision(outBytes), nil
 }
\ No newline at end of file
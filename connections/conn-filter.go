@@ -0,0 +1,102 @@
+package connections
+
+import (
+	"errors"
+	"sort"
+	"strings"
+
+	"github.com/bencase/revis-service/dto"
+)
+
+// Orders that connections can be listed in. Without one, connections are
+// listed in the order they were saved.
+const (
+	SortByName = "name"
+	SortByFolder = "folder"
+	SortByEnvironment = "environment"
+)
+
+var InvalidSortError = errors.New("Connections can only be sorted by " +
+	strings.Join([]string{SortByName, SortByFolder, SortByEnvironment}, ", "))
+
+// Criteria for listing connections, each of which is ignored when blank. A
+// folder matches its subfolders too, and a connection must have every tag.
+type ConnectionFilter struct {
+	Folder string
+	Tags []string
+	Environment string
+}
+
+func FilterConnections(conns []*dto.Connection, filter *ConnectionFilter) []*dto.Connection {
+	folder := strings.Trim(filter.Folder, "/")
+	filteredConns := make([]*dto.Connection, 0, len(conns))
+	for _, conn := range conns {
+		if folder != "" && conn.Folder != folder && !strings.HasPrefix(conn.Folder, folder + "/") {
+			continue
+		}
+		if filter.Environment != "" && conn.Environment != filter.Environment {
+			continue
+		}
+		if !hasAllTags(conn, filter.Tags) {
+			continue
+		}
+		filteredConns = append(filteredConns, conn)
+	}
+	return filteredConns
+}
+func hasAllTags(conn *dto.Connection, tags []string) bool {
+	for _, tag := range tags {
+		hasTag := false
+		for _, connTag := range conn.Tags {
+			if connTag == tag {
+				hasTag = true
+				break
+			}
+		}
+		if !hasTag {
+			return false
+		}
+	}
+	return true
+}
+
+// Sorts the connections in place. Connections that are equal by the chosen
+// order are sorted by name, and names are compared without case, so the order
+// is the same on every request.
+func SortConnections(conns []*dto.Connection, sortBy string) error {
+	var compare func(a *dto.Connection, b *dto.Connection) int
+	switch sortBy {
+	case "" :
+		return nil
+	case SortByName :
+		compare = func(a *dto.Connection, b *dto.Connection) int { return 0 }
+	case SortByFolder :
+		// Connections outside any folder come first
+		compare = func(a *dto.Connection, b *dto.Connection) int {
+			return strings.Compare(strings.ToLower(a.Folder), strings.ToLower(b.Folder))
+		}
+	case SortByEnvironment :
+		// Production first, then staging, dev and unmarked connections
+		compare = func(a *dto.Connection, b *dto.Connection) int {
+			return getEnvironmentRank(a.Environment) - getEnvironmentRank(b.Environment)
+		}
+	default :
+		return InvalidSortError
+	}
+	sort.SliceStable(conns, func(i, j int) bool {
+		if cmp := compare(conns[i], conns[j]); cmp != 0 {
+			return cmp < 0
+		}
+		return strings.ToLower(GetEffectiveNameOfConn(conns[i])) <
+			strings.ToLower(GetEffectiveNameOfConn(conns[j]))
+	})
+	return nil
+}
+func getEnvironmentRank(env string) int {
+	switch env {
+	case dto.EnvProd : return 0
+	case dto.EnvStaging : return 1
+	case dto.EnvDev : return 2
+	default : return 3
+	}
+}
\ No newline at end of file
@@ -0,0 +1,61 @@
+package connections
+
+import (
+	"os"
+
+	"github.com/bencase/revis-service/connections/encrypt"
+)
+
+func IsStoreLocked() bool {
+	return encrypt.IsLocked()
+}
+func MasterPasswordEnabled() bool {
+	return encrypt.MasterPasswordEnabled()
+}
+
+func UnlockStore(password string) error {
+	return encrypt.Unlock(password)
+}
+func LockStore() error {
+	return encrypt.Lock()
+}
+
+// Protects the store with a master password, or changes the password if it's
+// already protected, in which case the current password must be given. Every
+// secret is decrypted with the current key and re-encrypted with the one
+// derived from the new password. The files are replaced the way a key rotation
+// replaces them, with the connections written before the master key file, so
+// that a failure or crash partway through is rolled back.
+func SetMasterPassword(currentPassword string, password string) error {
+	if encrypt.IsLocked() {
+		return StoreLockedError
+	}
+	unlock, err := acquireStoreLock()
+	if err != nil { return err }
+	defer unlock()
+	conns, err := ReadConnections()
+	if err != nil { return err }
+	hadMasterPassword := encrypt.MasterPasswordEnabled()
+	pendingKey, err := encrypt.NewMasterPasswordKey(currentPassword, password)
+	if err != nil { return err }
+	connsBytes, err := getConnsBytesForKey(conns, pendingKey)
+	if err != nil { return err }
+
+	err = writeKeyFiles(&fileWrite{path: getConnsPath(), contents: connsBytes},
+		&fileWrite{path: pendingKey.Path, contents: pendingKey.FileBytes})
+	if err != nil { return err }
+	pendingKey.Activate()
+	if !hadMasterPassword {
+		err = encrypt.RemoveKeyFile()
+		if err != nil { return err }
+	}
+	err = os.Remove(getRotationMarkerPath())
+	if err != nil { return err }
+	if !hadMasterPassword {
+		// The backups hold the unprotected key and the secrets it decrypts
+		err = removeKeyFileBackups()
+		if err != nil { return err }
+	}
+	logger.Info("Set master password for connection store")
+	return nil
+}
\ No newline at end of file
@@ -0,0 +1,24 @@
+package connections
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+)
+
+var RevisionMismatchError = errors.New("The connections have been changed since they were read")
+
+// Identifies the contents of the connections file. Any write, including ones
+// made by another process, gives a new revision.
+func getRevision(fileBytes []byte) string {
+	sum := sha256.Sum256(fileBytes)
+	return hex.EncodeToString(sum[:16])
+}
+
+// A blank expected revision skips the check, for clients that don't send one.
+func checkRevision(expectedRevision string, revision string) error {
+	if expectedRevision != "" && expectedRevision != revision {
+		return RevisionMismatchError
+	}
+	return nil
+}
\ No newline at end of file
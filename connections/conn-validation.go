@@ -0,0 +1,222 @@
+package connections
+
+import (
+	"net"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/bencase/revis-service/dto"
+)
+
+var colorRegex = regexp.MustCompile(`^#([0-9a-fA-F]{3}|[0-9a-fA-F]{6})$`)
+
+// Returned when connections being saved are invalid, listing every problem found.
+type ValidationError struct {
+	Fields []*dto.FieldError
+}
+func (this *ValidationError) Error() string {
+	messages := make([]string, 0, len(this.Fields))
+	for _, field := range this.Fields {
+		messages = append(messages, field.Field + ": " + field.Message)
+	}
+	return "Invalid connections: " + strings.Join(messages, "; ")
+}
+
+// Checks the fields of each connection in the request.
+func validateUpserts(reqObj *dto.UpsertConnectionsRequest) error {
+	fieldErrs := make([]*dto.FieldError, 0)
+	for i, connUpsert := range reqObj.Connections {
+		if connUpsert.NewConn == nil {
+			fieldErrs = append(fieldErrs, newFieldError(i, "newConn", "A connection is required"))
+			continue
+		}
+		fieldErrs = append(fieldErrs, validateConnection(i, connUpsert.NewConn)...)
+	}
+	return newValidationError(fieldErrs)
+}
+
+// Checks that no connection in the request shares an effective name with
+// another once the upsert has been applied to allConns. Connections without
+// an explicit name are named after their address, so two of those can collide,
+// as can an explicit name with one of them.
+func checkNameCollisions(reqObj *dto.UpsertConnectionsRequest, allConns []*dto.Connection) error {
+	fieldErrs := make([]*dto.FieldError, 0)
+	for i, connUpsert := range reqObj.Connections {
+		newConn := connUpsert.NewConn
+		name := GetEffectiveNameOfConn(newConn)
+		matches := make([]*dto.Connection, 0)
+		for _, conn := range allConns {
+			if GetEffectiveNameOfConn(conn) == name {
+				matches = append(matches, conn)
+			}
+		}
+		if len(matches) <= 1 {
+			continue
+		}
+		message := "Another connection is already named \"" + name + "\""
+		if newConn.Name == "" {
+			message = "Another connection is also identified as \"" + name +
+				"\"; give this one a name to tell them apart"
+		} else {
+			for _, match := range matches {
+				if match.Name == "" {
+					message = "\"" + name + "\" is the address of a connection that has no name"
+					break
+				}
+			}
+		}
+		fieldErrs = append(fieldErrs, newFieldError(i, "name", message))
+	}
+	return newValidationError(fieldErrs)
+}
+
+func newValidationError(fieldErrs []*dto.FieldError) error {
+	if len(fieldErrs) == 0 {
+		return nil
+	}
+	return &ValidationError{Fields: fieldErrs}
+}
+
+func validateConnection(index int, conn *dto.Connection) []*dto.FieldError {
+	fieldErrs := make([]*dto.FieldError, 0)
+	addErr := func(field string, message string) {
+		fieldErrs = append(fieldErrs, newFieldError(index, field, message))
+	}
+
+	if conn.Name != strings.TrimSpace(conn.Name) {
+		addErr("name", "The name can't start or end with whitespace")
+	}
+	if conn.Db < 0 {
+		addErr("db", "The database number can't be negative")
+	}
+	switch conn.Type {
+	case dto.ConnTypeStandalone :
+		if conn.SocketPath == "" {
+			validateHostAndPort(conn.Host, conn.Port, "host", "port", addErr)
+		}
+	case dto.ConnTypeCluster :
+		validateHostAndPort(conn.Host, conn.Port, "host", "port", addErr)
+		if conn.SocketPath != "" {
+			addErr("socketPath", "Socket paths are only supported on standalone connections")
+		}
+		if conn.Db > 0 {
+			addErr("db", "Cluster connections can only use database 0")
+		}
+	case dto.ConnTypeSentinel :
+		if conn.Sentinel == nil || len(conn.Sentinel.Addrs) == 0 {
+			addErr("sentinel.addrs", "At least one sentinel address is required")
+		} else {
+			for j, addr := range conn.Sentinel.Addrs {
+				field := "sentinel.addrs[" + strconv.Itoa(j) + "]"
+				host, port, err := net.SplitHostPort(addr)
+				if err != nil {
+					addErr(field, "The address must be in host:port form")
+					continue
+				}
+				validateHostAndPort(host, port, field, field, addErr)
+			}
+		}
+		if conn.Sentinel == nil || conn.Sentinel.MasterName == "" {
+			addErr("sentinel.masterName", "The master name is required")
+		}
+		if conn.SocketPath != "" {
+			addErr("socketPath", "Socket paths are only supported on standalone connections")
+		}
+	default :
+		addErr("type", "Unknown connection type \"" + conn.Type + "\"")
+	}
+
+	if conn.Folder != "" {
+		for _, segment := range strings.Split(conn.Folder, "/") {
+			if strings.TrimSpace(segment) == "" {
+				addErr("folder", "Folder paths can't have empty segments or leading or trailing slashes")
+				break
+			}
+		}
+	}
+	for j, tag := range conn.Tags {
+		field := "tags[" + strconv.Itoa(j) + "]"
+		if strings.TrimSpace(tag) == "" {
+			addErr(field, "Tags can't be blank")
+		}
+		for _, prevTag := range conn.Tags[:j] {
+			if prevTag == tag {
+				addErr(field, "The tag \"" + tag + "\" is repeated")
+				break
+			}
+		}
+	}
+	if conn.Color != "" && !colorRegex.MatchString(conn.Color) {
+		addErr("color", "The color must be a hex color such as #c0392b")
+	}
+	switch conn.Environment {
+	case "", dto.EnvDev, dto.EnvStaging, dto.EnvProd :
+	default :
+		addErr("environment", "The environment must be one of " +
+			strings.Join([]string{dto.EnvDev, dto.EnvStaging, dto.EnvProd}, ", "))
+	}
+
+	switch conn.Protection {
+	case dto.ProtectionUnrestricted, dto.ProtectionConfirm, dto.ProtectionReadOnly :
+	default :
+		addErr("protection", "The protection must be one of " +
+			strings.Join([]string{dto.ProtectionConfirm, dto.ProtectionReadOnly}, ", ") +
+			", or blank for unrestricted")
+	}
+
+	// The decoder names are checked when values are decoded, since custom
+	// decoders are registered with the redis package
+	for j, rule := range conn.Decoders {
+		field := "decoders[" + strconv.Itoa(j) + "]"
+		if rule == nil || len(rule.Decoders) == 0 {
+			addErr(field + ".decoders", "At least one decoder is required")
+			continue
+		}
+		for k, name := range rule.Decoders {
+			if strings.TrimSpace(name) == "" {
+				addErr(field + ".decoders[" + strconv.Itoa(k) + "]", "Decoder names can't be blank")
+			}
+		}
+	}
+
+	if conn.TlsEnabled() && (conn.Tls.ClientCert == "") != (conn.Tls.ClientKey == "") {
+		addErr("tls.clientKey", "A client certificate and key must be given together")
+	}
+	if conn.SshEnabled() {
+		if conn.Ssh.Host == "" {
+			addErr("ssh.host", "The SSH host is required")
+		}
+		if conn.Ssh.Port != "" && !isValidPort(conn.Ssh.Port) {
+			addErr("ssh.port", "The SSH port must be a number from 1 to 65535")
+		}
+		if conn.Ssh.User == "" {
+			addErr("ssh.user", "The SSH user is required")
+		}
+		if conn.Ssh.Password == "" && conn.Ssh.PrivateKey == "" {
+			addErr("ssh.privateKey", "A private key or a password is required for SSH")
+		}
+	}
+	return fieldErrs
+}
+
+func validateHostAndPort(host string, port string, hostField string, portField string,
+		addErr func(string, string)) {
+	if strings.TrimSpace(host) == "" {
+		addErr(hostField, "The host is required")
+	} else if strings.ContainsAny(host, " \t/") {
+		addErr(hostField, "The host \"" + host + "\" is not valid")
+	}
+	if !isValidPort(port) {
+		addErr(portField, "The port must be a number from 1 to 65535")
+	}
+}
+
+func isValidPort(port string) bool {
+	portNum, err := strconv.Atoi(port)
+	return err == nil && portNum >= 1 && portNum <= 65535
+}
+
+func newFieldError(index int, field string, message string) *dto.FieldError {
+	return &dto.FieldError{Index: index, Field: field, Message: message}
+}
\ No newline at end of file
@@ -0,0 +1,139 @@
+package connections
+
+import (
+	"errors"
+	"io/ioutil"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v2"
+
+	"github.com/bencase/revis-service/config"
+	"github.com/bencase/revis-service/connections/encrypt"
+	"github.com/bencase/revis-service/dto"
+	"github.com/bencase/revis-service/util"
+)
+
+// Previous versions of the connections file are kept in this directory,
+// next to the file itself
+const versionsDirName = "conns-versions"
+const versionFilePrefix = "conns-"
+const versionFileSuffix = ".yml"
+const maxConnectionsVersions = 10
+
+var ConnectionsVersionNotFoundError = errors.New("Could not find a previous version of the connections with that ID")
+var UndecryptableVersionError = errors.New(
+	"That version of the connections was encrypted with a key that's no longer in use")
+
+// Lists the saved previous versions of the connections file, newest first.
+func GetConnectionsVersions() ([]*dto.ConnectionsVersion, error) {
+	ids, err := getVersionIds()
+	if err != nil { return nil, err }
+	versions := make([]*dto.ConnectionsVersion, 0, len(ids))
+	for _, id := range ids {
+		conns, err := readConnectionsVersion(id)
+		if err != nil { return nil, err }
+		versions = append(versions, &dto.ConnectionsVersion{Id: strconv.FormatInt(id, 10),
+			SavedAt: time.Unix(0, id).UTC().Format(time.RFC3339),
+			ConnectionCount: len(conns)})
+	}
+	return versions, nil
+}
+
+// Replaces the connections with a previous version. The current connections
+// are saved as a version first, so a restore can itself be undone.
+func RestoreConnectionsVersion(idStr string) error {
+	if encrypt.IsLocked() {
+		return StoreLockedError
+	}
+	id, err := strconv.ParseInt(idStr, 10, 64)
+	if err != nil { return ConnectionsVersionNotFoundError }
+	unlock, err := acquireStoreLock()
+	if err != nil { return err }
+	defer unlock()
+
+	conns, err := readConnectionsVersion(id)
+	if os.IsNotExist(err) {
+		return ConnectionsVersionNotFoundError
+	}
+	if err != nil { return err }
+	// Versions saved before a key rotation or master password change can't be decrypted anymore
+	for _, conn := range conns {
+		for _, secret := range getSecretsOfConn(conn) {
+			if *secret == "" {
+				continue
+			}
+			if _, err = encrypt.DecryptFromBase64(*secret); err != nil {
+				return UndecryptableVersionError
+			}
+		}
+	}
+	err = writeConnections(conns)
+	if err != nil { return err }
+	logger.Info("Restored connections from version", idStr)
+	return nil
+}
+
+// Copies the current connections file into the versions directory, removing
+// the oldest versions beyond the limit.
+func saveConnectionsVersion() error {
+	if fileDoesntExist() {
+		return nil
+	}
+	err := os.MkdirAll(getVersionsDir(), 0700)
+	if err != nil { return err }
+	_, err = util.CopyFileAtomic(getConnsPath(), getVersionPath(time.Now().UnixNano()), 0600)
+	if err != nil { return err }
+
+	ids, err := getVersionIds()
+	if err != nil { return err }
+	for i := maxConnectionsVersions; i < len(ids); i++ {
+		err = os.Remove(getVersionPath(ids[i]))
+		if err != nil { return err }
+	}
+	return nil
+}
+
+func readConnectionsVersion(id int64) ([]*dto.Connection, error) {
+	inBytes, err := ioutil.ReadFile(getVersionPath(id))
+	if err != nil { return nil, err }
+	conns := make([]*dto.Connection, 0)
+	err = yaml.Unmarshal(inBytes, &conns)
+	return conns, err
+}
+
+// Returns the IDs of the saved versions, newest first. An ID is the time the
+// version was replaced, in nanoseconds since the epoch.
+func getVersionIds() ([]int64, error) {
+	files, err := ioutil.ReadDir(getVersionsDir())
+	if os.IsNotExist(err) {
+		return []int64{}, nil
+	}
+	if err != nil { return nil, err }
+	ids := make([]int64, 0, len(files))
+	for _, file := range files {
+		name := file.Name()
+		if !strings.HasPrefix(name, versionFilePrefix) || !strings.HasSuffix(name, versionFileSuffix) {
+			continue
+		}
+		idStr := strings.TrimSuffix(strings.TrimPrefix(name, versionFilePrefix), versionFileSuffix)
+		id, err := strconv.ParseInt(idStr, 10, 64)
+		if err != nil {
+			continue
+		}
+		ids = append(ids, id)
+	}
+	sort.Slice(ids, func(i, j int) bool { return ids[i] > ids[j] })
+	return ids, nil
+}
+
+func getVersionsDir() string {
+	return config.LibraryPath + versionsDirName
+}
+func getVersionPath(id int64) string {
+	return getVersionsDir() + string(os.PathSeparator) + versionFilePrefix +
+		strconv.FormatInt(id, 10) + versionFileSuffix
+}
\ No newline at end of file
@@ -11,9 +11,65 @@ func GetEffectiveNameOfConn(conn *dto.Connection) string {
 		return conn.Name
 	} else {
 		name := conn.Host + ":" + conn.Port
+		if conn.UsesSocket() {
+			name = "unix:" + conn.SocketPath
+		}
+		// Sentinel connections are named after the master they follow
+		if conn.UsesSentinel() {
+			name = conn.Sentinel.MasterName
+			if len(conn.Sentinel.Addrs) > 0 {
+				name = name + "@" + conn.Sentinel.Addrs[0]
+			}
+		}
 		if conn.Db >= 1 {
 			name = name + "[" + strconv.Itoa(conn.Db) + "]"
 		}
 		return name
 	}
+}
+
+// Returns pointers to each of the connection's secret fields, all of which
+// are stored encrypted.
+func getSecretsOfConn(conn *dto.Connection) []*string {
+	secrets := []*string{&conn.Password}
+	if conn.Tls != nil {
+		secrets = append(secrets, &conn.Tls.ClientKey)
+	}
+	if conn.Ssh != nil {
+		secrets = append(secrets, &conn.Ssh.Password, &conn.Ssh.PrivateKey, &conn.Ssh.Passphrase)
+	}
+	return secrets
+}
+
+// Copies the connection along with its nested configs, so that the copy's
+// secrets can be changed without affecting the original.
+func copyConn(conn *dto.Connection) *dto.Connection {
+	connCopy := *conn
+	connCopy.Tags = append([]string(nil), conn.Tags...)
+	if conn.Decoders != nil {
+		connCopy.Decoders = make([]*dto.DecoderRule, len(conn.Decoders))
+		for i, rule := range conn.Decoders {
+			// Nil rules are left for validation to reject
+			if rule == nil {
+				continue
+			}
+			ruleCopy := *rule
+			ruleCopy.Decoders = append([]string(nil), rule.Decoders...)
+			connCopy.Decoders[i] = &ruleCopy
+		}
+	}
+	if conn.Tls != nil {
+		tlsCopy := *conn.Tls
+		connCopy.Tls = &tlsCopy
+	}
+	if conn.Sentinel != nil {
+		sentinelCopy := *conn.Sentinel
+		sentinelCopy.Addrs = append([]string{}, conn.Sentinel.Addrs...)
+		connCopy.Sentinel = &sentinelCopy
+	}
+	if conn.Ssh != nil {
+		sshCopy := *conn.Ssh
+		connCopy.Ssh = &sshCopy
+	}
+	return &connCopy
 }
\ No newline at end of file
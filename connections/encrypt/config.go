@@ -0,0 +1,7 @@
+package encrypt
+
+import (
+	glogging "github.com/op/go-logging"
+)
+
+var logger = glogging.MustGetLogger("encrypt")
\ No newline at end of file
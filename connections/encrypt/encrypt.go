@@ -11,24 +11,86 @@ import (
 	"io"
 	"io/ioutil"
 	"os"
+	"runtime"
 	"strings"
+
+	"github.com/bencase/revis-service/config"
 )
 
-const keyFilename = "key.txt"
+const keyFilename = config.KeyFilename
+// The key that earlier versions used when there was no key file. It is public,
+// so it is only used to read secrets that need re-encrypting.
 const defaultHexStr = "f35116b13bd7345ff8f559c854a9b2accc108451bac36040400f06298b08e8b8"
 
+var KeyFileMissingError = errors.New("The encryption key file does not exist")
+
+// Generates a random key if the key file doesn't exist yet, and otherwise makes
+// sure the existing file isn't readable by other users. Returns true if a new
+// key was generated.
+func InitKey() (bool, error) {
+	// A master password takes the place of the key file
+	if MasterPasswordEnabled() {
+		return false, nil
+	}
+	keyPath := config.DataPath + keyFilename
+	info, err := os.Stat(keyPath)
+	if os.IsNotExist(err) {
+		err = generateKeyFile(keyPath)
+		if err != nil { return false, err }
+		logger.Info("Generated a new encryption key at", keyPath)
+		return true, nil
+	}
+	if err != nil { return false, err }
+	// Windows doesn't use Unix permission bits
+	if runtime.GOOS != "windows" && info.Mode().Perm() & 0004 != 0 {
+		return false, errors.New("The encryption key file " + keyPath +
+			" is readable by all users; restrict it with chmod 600")
+	}
+	return false, nil
+}
+func generateKeyFile(keyPath string) error {
+	key := make([]byte, 32)
+	_, err := io.ReadFull(rand.Reader, key)
+	if err != nil { return err }
+	// O_EXCL makes sure an existing key is never overwritten
+	file, err := os.OpenFile(keyPath, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0600)
+	if err != nil { return err }
+	_, err = file.WriteString(hex.EncodeToString(key))
+	if err == nil {
+		err = file.Sync()
+	}
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(keyPath)
+	}
+	return err
+}
+
+// Removes the key file once it's been replaced by a master password.
+func RemoveKeyFile() error {
+	err := os.Remove(config.DataPath + keyFilename)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	return err
+}
+
 func getKey() (*[32]byte, error) {
+	if MasterPasswordEnabled() {
+		return getUnlockedKey()
+	}
 	// Check if the key file exists
-	_, err := os.Stat(keyFilename)
-	fileExists := !os.IsNotExist(err)
-	var hexStr string
-	if fileExists {
-		hexStr, err = getHexStrFromFile()
-		if err != nil { return nil, err }
-	} else {
-		hexStr = getDefaultHexStr()
+	_, err := os.Stat(config.DataPath + keyFilename)
+	if os.IsNotExist(err) {
+		return nil, KeyFileMissingError
 	}
-
+	hexStr, err := getHexStrFromFile()
+	if err != nil { return nil, err }
+	return keyFromHexStr(hexStr)
+}
+func keyFromHexStr(hexStr string) (*[32]byte, error) {
 	decodedBytes, err := hex.DecodeString(hexStr)
 	if err != nil { return nil, err }
 	if len(decodedBytes) < 32 { return nil, errors.New("Key " + hexStr + " is not long enough") }
@@ -38,14 +100,14 @@ func getKey() (*[32]byte, error) {
 	return &key, nil
 }
 func getHexStrFromFile() (string, error) {
-	fileBytes, err := ioutil.ReadFile(keyFilename)
+	fileBytes, err := ioutil.ReadFile(config.DataPath + keyFilename)
 	if err != nil { return "", err }
 
 	hexStr := string(fileBytes)
 	return strings.TrimSpace(hexStr), nil
 }
-func getDefaultHexStr() string {
-	return defaultHexStr
+func getLegacyKey() (*[32]byte, error) {
+	return keyFromHexStr(defaultHexStr)
 }
 
 func EncryptToBase64(text string) (string, error) {
@@ -56,7 +118,9 @@ func EncryptToBase64(text string) (string, error) {
 func Encrypt(text string) ([]byte, error) {
 	key, err := getKey()
 	if err != nil { return nil, err }
-
+	return encryptWithKey(text, key)
+}
+func encryptWithKey(text string, key *[32]byte) ([]byte, error) {
 	block, err := aes.NewCipher(key[:])
 	if err != nil { return nil, err }
 	
@@ -78,7 +142,19 @@ func DecryptFromBase64(b64Text string) (string, error) {
 func Decrypt(ciphertext []byte) (string, error) {
 	key, err := getKey()
 	if err != nil { return "", err }
+	return decryptWithKey(ciphertext, key)
+}
+
+// Decrypts text that was encrypted with the legacy built-in key.
+func DecryptLegacyFromBase64(b64Text string) (string, error) {
+	decodedBytes, err := base64.StdEncoding.DecodeString(b64Text)
+	if err != nil { return "", err }
+	key, err := getLegacyKey()
+	if err != nil { return "", err }
+	return decryptWithKey(decodedBytes, key)
+}
 
+func decryptWithKey(ciphertext []byte, key *[32]byte) (string, error) {
 	block, err := aes.NewCipher(key[:])
 	if err != nil { return "", err }
 	
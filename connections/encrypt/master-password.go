@@ -0,0 +1,159 @@
+package encrypt
+
+import (
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"io"
+	"io/ioutil"
+	"os"
+	"sync"
+
+	"golang.org/x/crypto/scrypt"
+	"gopkg.in/yaml.v2"
+
+	"github.com/bencase/revis-service/config"
+)
+
+const masterKeyFilename = config.MasterKeyFilename
+const kdfScrypt = "scrypt"
+// scrypt cost parameters; N=2^15 takes roughly 100ms and 32MB per derivation
+const (
+	scryptN = 32768
+	scryptR = 8
+	scryptP = 1
+)
+const saltSize = 16
+// Encrypted with the derived key so that a wrong password can be told apart
+const checkText = "revis-master-password-check"
+
+var StoreLockedError = errors.New("The connection store is locked; unlock it with the master password")
+var WrongMasterPasswordError = errors.New("The master password is incorrect")
+var MasterPasswordNotEnabledError = errors.New("The connection store is not protected by a master password")
+
+// The parameters needed to derive the key from the master password again
+type masterKeyFile struct {
+	Kdf string `yaml:"kdf"`
+	Salt string `yaml:"salt"`
+	N int `yaml:"n"`
+	R int `yaml:"r"`
+	P int `yaml:"p"`
+	Check string `yaml:"check"`
+}
+
+var stateMutex = &sync.RWMutex{}
+// The key derived from the master password, or nil while the store is locked
+var unlockedKey *[32]byte
+
+// True if the key is derived from a master password rather than read from the key file.
+func MasterPasswordEnabled() bool {
+	_, err := os.Stat(config.DataPath + masterKeyFilename)
+	return err == nil
+}
+
+func IsLocked() bool {
+	if !MasterPasswordEnabled() {
+		return false
+	}
+	stateMutex.RLock()
+	defer stateMutex.RUnlock()
+	return unlockedKey == nil
+}
+
+func Unlock(password string) error {
+	key, err := getKeyForMasterPassword(password)
+	if err != nil { return err }
+	stateMutex.Lock()
+	defer stateMutex.Unlock()
+	unlockedKey = key
+	logger.Info("Unlocked the connection store")
+	return nil
+}
+
+func Lock() error {
+	if !MasterPasswordEnabled() {
+		return MasterPasswordNotEnabledError
+	}
+	stateMutex.Lock()
+	defer stateMutex.Unlock()
+	unlockedKey = nil
+	logger.Info("Locked the connection store")
+	return nil
+}
+
+// Derives a key from the password with a fresh salt.
+func newMasterKeyFile(password string) (*masterKeyFile, *[32]byte, error) {
+	if password == "" {
+		return nil, nil, errors.New("The master password can't be blank")
+	}
+	salt := make([]byte, saltSize)
+	_, err := io.ReadFull(rand.Reader, salt)
+	if err != nil { return nil, nil, err }
+	keyFile := &masterKeyFile{Kdf: kdfScrypt,
+		Salt: hex.EncodeToString(salt),
+		N: scryptN,
+		R: scryptR,
+		P: scryptP}
+	key, err := deriveKey(password, keyFile)
+	if err != nil { return nil, nil, err }
+	check, err := encryptWithKey(checkText, key)
+	if err != nil { return nil, nil, err }
+	keyFile.Check = base64.StdEncoding.EncodeToString(check)
+	return keyFile, key, nil
+}
+
+// Derives the key from the password and the stored salt, returning an error
+// if the password is wrong.
+func getKeyForMasterPassword(password string) (*[32]byte, error) {
+	keyFile, err := readMasterKeyFile()
+	if err != nil { return nil, err }
+	key, err := deriveKey(password, keyFile)
+	if err != nil { return nil, err }
+	check, err := base64.StdEncoding.DecodeString(keyFile.Check)
+	if err != nil { return nil, err }
+	decryptedCheck, err := decryptWithKey(check, key)
+	if err != nil || decryptedCheck != checkText {
+		return nil, WrongMasterPasswordError
+	}
+	return key, nil
+}
+func checkMasterPassword(password string) error {
+	_, err := getKeyForMasterPassword(password)
+	return err
+}
+
+// Returns the derived key, or an error if the store is locked.
+func getUnlockedKey() (*[32]byte, error) {
+	stateMutex.RLock()
+	defer stateMutex.RUnlock()
+	if unlockedKey == nil {
+		return nil, StoreLockedError
+	}
+	key := *unlockedKey
+	return &key, nil
+}
+
+func deriveKey(password string, keyFile *masterKeyFile) (*[32]byte, error) {
+	if keyFile.Kdf != kdfScrypt {
+		return nil, errors.New("Unsupported key derivation function " + keyFile.Kdf)
+	}
+	salt, err := hex.DecodeString(keyFile.Salt)
+	if err != nil { return nil, err }
+	derivedBytes, err := scrypt.Key([]byte(password), salt, keyFile.N, keyFile.R, keyFile.P, 32)
+	if err != nil { return nil, err }
+	key := [32]byte{}
+	copy(key[:], derivedBytes)
+	return &key, nil
+}
+
+func readMasterKeyFile() (*masterKeyFile, error) {
+	if !MasterPasswordEnabled() {
+		return nil, MasterPasswordNotEnabledError
+	}
+	fileBytes, err := ioutil.ReadFile(config.DataPath + masterKeyFilename)
+	if err != nil { return nil, err }
+	keyFile := &masterKeyFile{}
+	err = yaml.Unmarshal(fileBytes, keyFile)
+	return keyFile, err
+}
\ No newline at end of file
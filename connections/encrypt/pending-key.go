@@ -0,0 +1,83 @@
+package encrypt
+
+import (
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/hex"
+	"io"
+
+	"gopkg.in/yaml.v2"
+
+	"github.com/bencase/revis-service/config"
+)
+
+// A newly generated key that secrets can be encrypted with before it replaces
+// the current key. FileBytes are the contents of the file at Path that will
+// hold it: the key file, or the master key file when a master password is used.
+type PendingKey struct {
+	key *[32]byte
+	Path string
+	FileBytes []byte
+}
+
+// Generates a replacement for the current key. If the store is protected by a
+// master password, the password must be given, and the new key is derived from
+// it with a fresh salt.
+func NewPendingKey(masterPassword string) (*PendingKey, error) {
+	if MasterPasswordEnabled() {
+		return NewMasterPasswordKey(masterPassword, masterPassword)
+	}
+	key := [32]byte{}
+	_, err := io.ReadFull(rand.Reader, key[:])
+	if err != nil { return nil, err }
+	return &PendingKey{key: &key,
+		Path: GetKeyFilePath(),
+		FileBytes: []byte(hex.EncodeToString(key[:]))}, nil
+}
+
+// Derives a key from a new master password with a fresh salt, which replaces
+// the current key whether or not that's already derived from one. If it is,
+// the current master password must be given.
+func NewMasterPasswordKey(currentPassword string, password string) (*PendingKey, error) {
+	if MasterPasswordEnabled() {
+		err := checkMasterPassword(currentPassword)
+		if err != nil { return nil, err }
+	}
+	keyFile, key, err := newMasterKeyFile(password)
+	if err != nil { return nil, err }
+	fileBytes, err := yaml.Marshal(keyFile)
+	if err != nil { return nil, err }
+	return &PendingKey{key: key,
+		Path: config.DataPath + masterKeyFilename,
+		FileBytes: fileBytes}, nil
+}
+
+func (this *PendingKey) EncryptToBase64(text string) (string, error) {
+	ciphertext, err := encryptWithKey(text, this.key)
+	if err != nil { return "", err }
+	return base64.StdEncoding.EncodeToString(ciphertext), nil
+}
+
+// Makes the key current once its file has been written. Only needed for a
+// master password, since the key file is read on every use.
+func (this *PendingKey) Activate() {
+	if MasterPasswordEnabled() {
+		stateMutex.Lock()
+		defer stateMutex.Unlock()
+		unlockedKey = this.key
+	}
+}
+
+// Returns the paths of the key file and the master key file, only one of
+// which is normally in use.
+func GetKeyFilePaths() []string {
+	return []string{config.DataPath + keyFilename, config.DataPath + masterKeyFilename}
+}
+
+// Returns the path of the file holding the current key.
+func GetKeyFilePath() string {
+	if MasterPasswordEnabled() {
+		return config.DataPath + masterKeyFilename
+	}
+	return config.DataPath + keyFilename
+}
\ No newline at end of file
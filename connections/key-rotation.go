@@ -0,0 +1,164 @@
+package connections
+
+import (
+	"errors"
+	"os"
+
+	"gopkg.in/yaml.v2"
+
+	"github.com/bencase/revis-service/config"
+	"github.com/bencase/revis-service/connections/encrypt"
+	"github.com/bencase/revis-service/dto"
+	"github.com/bencase/revis-service/util"
+)
+
+const backupSuffix = ".bak"
+// Present in the data directory while a rotation or master password change is
+// writing files, so that one interrupted partway through can be rolled back
+// on the next start
+const rotationMarkerFilename = "key-rotation.pending"
+
+// Replaces the encryption key with a newly generated one, re-encrypting every
+// secret. If the store is protected by a master password, the password must
+// be given. The previous key and connections file are kept as backups, which
+// are restored if anything fails.
+func RotateKey(masterPassword string) error {
+	if encrypt.IsLocked() {
+		return StoreLockedError
+	}
+	unlock, err := acquireStoreLock()
+	if err != nil { return err }
+	defer unlock()
+	conns, err := ReadConnections()
+	if err != nil { return err }
+	pendingKey, err := encrypt.NewPendingKey(masterPassword)
+	if err != nil { return err }
+	connsBytes, err := getConnsBytesForKey(conns, pendingKey)
+	if err != nil { return err }
+
+	err = writeKeyFiles(&fileWrite{path: pendingKey.Path, contents: pendingKey.FileBytes},
+		&fileWrite{path: getConnsPath(), contents: connsBytes})
+	if err != nil { return err }
+	pendingKey.Activate()
+	err = os.Remove(getRotationMarkerPath())
+	if err != nil { return err }
+	logger.Info("Rotated encryption key for", len(conns), "connections")
+	return nil
+}
+
+type fileWrite struct {
+	path string
+	contents []byte
+}
+
+// Encrypts every secret with the new key, so that it's done before any file
+// is touched.
+func getConnsBytesForKey(conns []*dto.Connection, pendingKey *encrypt.PendingKey) ([]byte, error) {
+	var err error
+	for _, conn := range conns {
+		for _, secret := range getSecretsOfConn(conn) {
+			if *secret != "" {
+				*secret, err = pendingKey.EncryptToBase64(*secret)
+				if err != nil { return nil, err }
+			}
+		}
+	}
+	return yaml.Marshal(conns)
+}
+
+// Backs up the key and connections files and writes the new ones in order,
+// rolling back if any write fails. The marker is left for the caller to
+// remove once the new key is in use.
+func writeKeyFiles(writes ...*fileWrite) error {
+	err := backUpKeyFiles()
+	if err != nil { return err }
+	err = util.WriteFileAtomic(getRotationMarkerPath(), []byte(writes[0].path), 0600)
+	if err != nil { return err }
+	for _, write := range writes {
+		err = util.WriteFileAtomic(write.path, write.contents, 0600)
+		if err != nil {
+			logger.Error("Error writing", write.path, "for new key, rolling back:", err)
+			rollbackErr := rollBackKeyRotation()
+			if rollbackErr != nil {
+				logger.Error("Error rolling back key files:", rollbackErr)
+			}
+			return err
+		}
+	}
+	return nil
+}
+
+// Restores the key and connections files saved by the last rotation or master
+// password change.
+func RollBackKeyRotation() error {
+	unlock, err := acquireStoreLock()
+	if err != nil { return err }
+	defer unlock()
+	return rollBackKeyRotation()
+}
+func rollBackKeyRotation() error {
+	paths := getKeyFilesToBackUp()
+	hasBackup := false
+	for _, path := range paths {
+		hasBackup = hasBackup || util.FileExists(path + backupSuffix)
+	}
+	if !hasBackup {
+		return errors.New("There is no key rotation backup to roll back to")
+	}
+	for _, path := range paths {
+		copied, err := util.CopyFileAtomic(path + backupSuffix, path, 0600)
+		if err != nil { return err }
+		// A file without a backup didn't exist before, as with the master key
+		// file when a master password is first set
+		if !copied {
+			err = os.Remove(path)
+			if err != nil && !os.IsNotExist(err) { return err }
+		}
+	}
+	// The key derived from the master password may be the rotated one
+	if encrypt.MasterPasswordEnabled() {
+		err := encrypt.Lock()
+		if err != nil { return err }
+	}
+	err := os.Remove(getRotationMarkerPath())
+	if err != nil && !os.IsNotExist(err) { return err }
+	logger.Info("Rolled back encryption key rotation")
+	return nil
+}
+
+// Rolls back a rotation that didn't finish, leaving the files as they were
+// before it started.
+func recoverInterruptedKeyRotation() error {
+	if !util.FileExists(getRotationMarkerPath()) {
+		return nil
+	}
+	logger.Warning("Found an interrupted key rotation, rolling back")
+	return RollBackKeyRotation()
+}
+
+func backUpKeyFiles() error {
+	paths := getKeyFilesToBackUp()
+	// Stale backups would otherwise be restored over files that were created
+	// after them
+	err := removeKeyFileBackups()
+	if err != nil { return err }
+	for _, path := range paths {
+		_, err = util.CopyFileAtomic(path, path + backupSuffix, 0600)
+		if err != nil { return err }
+	}
+	return nil
+}
+func removeKeyFileBackups() error {
+	for _, path := range getKeyFilesToBackUp() {
+		err := os.Remove(path + backupSuffix)
+		if err != nil && !os.IsNotExist(err) { return err }
+	}
+	return nil
+}
+func getKeyFilesToBackUp() []string {
+	return append(encrypt.GetKeyFilePaths(), getConnsPath())
+}
+
+func getRotationMarkerPath() string {
+	return config.DataPath + rotationMarkerFilename
+}
\ No newline at end of file
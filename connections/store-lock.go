@@ -0,0 +1,31 @@
+package connections
+
+import (
+	"sync"
+
+	"github.com/bencase/revis-service/config"
+	"github.com/bencase/revis-service/util"
+)
+
+const lockFilename = filename + ".lock"
+
+var storeMutex sync.Mutex
+
+// Serializes read-modify-write cycles on the connections file, both between
+// requests in this process and with other processes using the same directory,
+// such as the rotate-key command. The returned function releases the lock.
+func acquireStoreLock() (func(), error) {
+	storeMutex.Lock()
+	fileLock, err := util.LockFile(config.LibraryPath + lockFilename)
+	if err != nil {
+		storeMutex.Unlock()
+		return nil, err
+	}
+	return func() {
+		err := fileLock.Unlock()
+		if err != nil {
+			logger.Error("Error releasing lock on connections file:", err)
+		}
+		storeMutex.Unlock()
+	}, nil
+}
\ No newline at end of file
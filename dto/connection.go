@@ -1,9 +1,116 @@
 package dto
 
+// Connection types. A blank type is a single standalone server.
+const (
+	ConnTypeStandalone = ""
+	ConnTypeSentinel = "sentinel"
+	ConnTypeCluster = "cluster"
+)
+
+// Environments a connection can be marked with, which the UI uses to warn
+// before changes to production data
+const (
+	EnvDev = "dev"
+	EnvStaging = "staging"
+	EnvProd = "prod"
+)
+
+// How a connection guards against changes to its data. Unrestricted is the
+// default; connections that require confirmation only go ahead with a token
+// issued by the server for that exact operation.
+const (
+	ProtectionUnrestricted = ""
+	ProtectionConfirm = "confirm-destructive"
+	ProtectionReadOnly = "read-only"
+)
+
+// Names of the built-in value decoders. Auto detects the format of the value,
+// and none leaves it as it is.
+const (
+	DecoderAuto = "auto"
+	DecoderNone = "none"
+	DecoderJson = "json"
+	DecoderGzip = "gzip"
+	DecoderBase64 = "base64"
+	DecoderMsgpack = "msgpack"
+	DecoderPhp = "php"
+	DecoderJava = "java"
+)
+
 type Connection struct {
 	Name string `json:"name,omitempty" yaml:"name,omitempty"`
+	Type string `json:"type,omitempty" yaml:"type,omitempty"`
 	Host string `json:"host,omitempty" yaml:"host,omitempty"`
 	Port string `json:"port,omitempty" yaml:"port,omitempty"`
+	SocketPath string `json:"socketPath,omitempty" yaml:"socketPath,omitempty"`
+	Username string `json:"username,omitempty" yaml:"username,omitempty"`
 	Password string `json:"password,omitempty" yaml:"password,omitempty"`
 	Db int `json:"db,omitempty" yaml:"db,omitempty"`
+	Tls *TlsConfig `json:"tls,omitempty" yaml:"tls,omitempty"`
+	Sentinel *SentinelConfig `json:"sentinel,omitempty" yaml:"sentinel,omitempty"`
+	Ssh *SshTunnelConfig `json:"ssh,omitempty" yaml:"ssh,omitempty"`
+	// Slash-separated, such as "clients/acme"
+	Folder string `json:"folder,omitempty" yaml:"folder,omitempty"`
+	Tags []string `json:"tags,omitempty" yaml:"tags,omitempty"`
+	// A CSS hex color such as "#c0392b"
+	Color string `json:"color,omitempty" yaml:"color,omitempty"`
+	Environment string `json:"environment,omitempty" yaml:"environment,omitempty"`
+	Protection string `json:"protection,omitempty" yaml:"protection,omitempty"`
+	// The first rule whose pattern matches a key decides how its value is
+	// decoded. Values of keys that match no rule are auto-detected.
+	Decoders []*DecoderRule `json:"decoders,omitempty" yaml:"decoders,omitempty"`
+}
+
+// Decodes the values of keys matching the pattern with each of the decoders
+// in turn, such as gzip then json. A blank pattern matches every key.
+type DecoderRule struct {
+	Pattern string `json:"pattern,omitempty" yaml:"pattern,omitempty"`
+	Decoders []string `json:"decoders" yaml:"decoders"`
+}
+
+// The certificate and key fields hold PEM-encoded contents rather than file paths.
+type TlsConfig struct {
+	Enabled bool `json:"enabled,omitempty" yaml:"enabled,omitempty"`
+	CaCert string `json:"caCert,omitempty" yaml:"caCert,omitempty"`
+	ClientCert string `json:"clientCert,omitempty" yaml:"clientCert,omitempty"`
+	ClientKey string `json:"clientKey,omitempty" yaml:"clientKey,omitempty"`
+	ServerName string `json:"serverName,omitempty" yaml:"serverName,omitempty"`
+	SkipVerify bool `json:"skipVerify,omitempty" yaml:"skipVerify,omitempty"`
+}
+
+// Addrs are the host:port addresses of the sentinels, which are tried in order.
+type SentinelConfig struct {
+	Addrs []string `json:"addrs,omitempty" yaml:"addrs,omitempty"`
+	MasterName string `json:"masterName,omitempty" yaml:"masterName,omitempty"`
+}
+
+// The bastion host that connections are tunnelled through. The private key holds
+// PEM-encoded contents, and the known hosts file defaults to ~/.ssh/known_hosts.
+type SshTunnelConfig struct {
+	Enabled bool `json:"enabled,omitempty" yaml:"enabled,omitempty"`
+	Host string `json:"host,omitempty" yaml:"host,omitempty"`
+	Port string `json:"port,omitempty" yaml:"port,omitempty"`
+	User string `json:"user,omitempty" yaml:"user,omitempty"`
+	Password string `json:"password,omitempty" yaml:"password,omitempty"`
+	PrivateKey string `json:"privateKey,omitempty" yaml:"privateKey,omitempty"`
+	Passphrase string `json:"passphrase,omitempty" yaml:"passphrase,omitempty"`
+	KnownHostsFile string `json:"knownHostsFile,omitempty" yaml:"knownHostsFile,omitempty"`
+	SkipHostKeyCheck bool `json:"skipHostKeyCheck,omitempty" yaml:"skipHostKeyCheck,omitempty"`
+}
+
+// A socket path takes the place of the host and port.
+func (this *Connection) UsesSocket() bool {
+	return this.SocketPath != "" && this.Type == ConnTypeStandalone
+}
+func (this *Connection) TlsEnabled() bool {
+	return this.Tls != nil && this.Tls.Enabled
+}
+func (this *Connection) SshEnabled() bool {
+	return this.Ssh != nil && this.Ssh.Enabled
+}
+func (this *Connection) IsCluster() bool {
+	return this.Type == ConnTypeCluster
+}
+func (this *Connection) UsesSentinel() bool {
+	return this.Type == ConnTypeSentinel && this.Sentinel != nil
 }
\ No newline at end of file
@@ -25,6 +25,66 @@ type ConnUpsert struct {
 type DeleteConnectionsRequest struct {
 	ConnectionNames []string `json:"connectionNames"`
 }
+type MasterPasswordRequest struct {
+	Password string `json:"password"`
+	// Needed to change a master password that's already set
+	CurrentPassword string `json:"currentPassword,omitempty"`
+}
+type RestoreConnectionsVersionRequest struct {
+	Id string `json:"id"`
+}
+// Restore modes
+const (
+	RestoreSkipExisting = "skip-existing"
+	RestoreReplace = "replace"
+)
+// The backup can be restored into any connection. The mode defaults to skipping existing keys.
+type RestoreBackupRequest struct {
+	BackupId string `json:"backupId"`
+	ConnName string `json:"connName"`
+	Mode string `json:"mode,omitempty"`
+}
+type RotateKeyRequest struct {
+	// Only needed when the store is protected by a master password
+	MasterPassword string `json:"masterPassword,omitempty"`
+}
+// The group's ID is where it starts reading from, with "$" meaning only new
+// entries. With MkStream, the stream is created if it doesn't exist.
+type StreamGroupRequest struct {
+	Group string `json:"group"`
+	Id string `json:"id,omitempty"`
+	MkStream bool `json:"mkStream,omitempty"`
+}
+// Lists a consumer group's pending entries between two IDs, which default to
+// the whole stream.
+type PendingEntriesRequest struct {
+	Group string `json:"group"`
+	Consumer string `json:"consumer,omitempty"`
+	MinIdleMs int64 `json:"minIdleMs,omitempty"`
+	Start string `json:"start,omitempty"`
+	End string `json:"end,omitempty"`
+	Count int `json:"count,omitempty"`
+}
+// Claims the pending entries with the IDs for the consumer if they've been
+// idle for at least MinIdleMs milliseconds. Without IDs, up to Count entries
+// are claimed automatically, starting from the Start ID.
+type ClaimStreamEntriesRequest struct {
+	Group string `json:"group"`
+	Consumer string `json:"consumer"`
+	MinIdleMs int64 `json:"minIdleMs,omitempty"`
+	Ids []string `json:"ids,omitempty"`
+	Start string `json:"start,omitempty"`
+	Count int `json:"count,omitempty"`
+}
+type AckStreamEntriesRequest struct {
+	Group string `json:"group"`
+	Ids []string `json:"ids"`
+}
+type ImportConnectionUriRequest struct {
+	Uri string `json:"uri"`
+	// Overrides any name given in the URI
+	Name string `json:"name,omitempty"`
+}
 
 
 /// Responses ///
@@ -45,11 +105,32 @@ type KeysResponse struct {
 func (this *KeysResponse) JsonBytes() ([]byte, error) {
 	return json.Marshal(this)
 }
+// Encodings of binary data, which can't be put in JSON as it is
+const (
+	EncodingBase64 = "base64"
+	EncodingHex = "hex"
+)
+
+// The key's name and value are each encoded if they hold binary data, in
+// which case their encoding is given. For a value that holds several strings,
+// all of them are encoded if any is.
 type Key struct {
 	Key string `json:"key"`
+	KeyEncoding string `json:"keyEncoding,omitempty"`
 	Val interface{} `json:"val"`
+	ValEncoding string `json:"valEncoding,omitempty"`
 	Type string `json:"type,omitempty"`
 	ExpAt int64 `json:"expAt,omitempty"`
+	// The number of members of a list, set, sorted set or hash. Its value only
+	// holds the first of them, which are paged through separately.
+	Count int64 `json:"count,omitempty"`
+	// A string value decoded for display, such as from gzipped JSON, with the
+	// decoders used in order. The value itself is left as it is.
+	Decoded interface{} `json:"decoded,omitempty"`
+	DecodedWith []string `json:"decodedWith,omitempty"`
+	// Set if decoding left binary data, which is then encoded
+	DecodedEncoding string `json:"decodedEncoding,omitempty"`
+	DecodeError string `json:"decodeError,omitempty"`
 }
 type ZsetVal struct {
 	Zval string `json:"zval"`
@@ -59,10 +140,136 @@ type HashVal struct {
 	Hkey string `json:"hkey"`
 	Hval string `json:"hval"`
 }
+// The most recent entries of a stream, newest first, along with a summary of
+// the whole stream. Only a limited number of entries are included, so the
+// length may be greater than the number of entries.
+type StreamVal struct {
+	Length int64 `json:"length"`
+	FirstId string `json:"firstId,omitempty"`
+	LastId string `json:"lastId,omitempty"`
+	// The number of consumer groups
+	Groups int64 `json:"groups"`
+	Entries []*StreamEntry `json:"entries"`
+}
+type StreamEntry struct {
+	Id string `json:"id"`
+	Fields []*StreamField `json:"fields"`
+}
+type StreamField struct {
+	Field string `json:"field"`
+	Value string `json:"value"`
+}
+
+
+type StreamGroupsResponse struct {
+	Groups []*StreamGroup `json:"groups"`
+	ErrorContainer
+}
+func (this *StreamGroupsResponse) JsonBytes() ([]byte, error) {
+	return json.Marshal(this)
+}
+type StreamGroup struct {
+	Name string `json:"name"`
+	Consumers int64 `json:"consumers"`
+	Pending int64 `json:"pending"`
+	LastDeliveredId string `json:"lastDeliveredId"`
+	// Only given from Redis 7 on, and the lag isn't always known
+	EntriesRead *int64 `json:"entriesRead,omitempty"`
+	Lag *int64 `json:"lag,omitempty"`
+}
+// Idle and inactive times are in milliseconds. Inactive is only given from
+// Redis 7.2 on.
+type StreamConsumersResponse struct {
+	Consumers []*StreamConsumer `json:"consumers"`
+	ErrorContainer
+}
+func (this *StreamConsumersResponse) JsonBytes() ([]byte, error) {
+	return json.Marshal(this)
+}
+type StreamConsumer struct {
+	Name string `json:"name"`
+	Pending int64 `json:"pending"`
+	Idle int64 `json:"idle"`
+	Inactive *int64 `json:"inactive,omitempty"`
+}
+type PendingEntriesResponse struct {
+	Entries []*PendingEntry `json:"entries"`
+	ErrorContainer
+}
+func (this *PendingEntriesResponse) JsonBytes() ([]byte, error) {
+	return json.Marshal(this)
+}
+// Idle is the milliseconds since the entry was last delivered.
+type PendingEntry struct {
+	Id string `json:"id"`
+	Consumer string `json:"consumer"`
+	Idle int64 `json:"idle"`
+	Deliveries int64 `json:"deliveries"`
+}
+// The entries that were claimed. When claiming automatically, NextId is where
+// to start the next claim, which is "0-0" once the whole list has been gone
+// through, and DeletedIds are pending entries no longer in the stream.
+type ClaimedStreamEntries struct {
+	Entries []*StreamEntry `json:"entries"`
+	// Set if the entries' fields and values hold binary data
+	Encoding string `json:"encoding,omitempty"`
+	NextId string `json:"nextId,omitempty"`
+	DeletedIds []string `json:"deletedIds,omitempty"`
+}
+type ClaimStreamEntriesResponse struct {
+	ClaimedStreamEntries
+	ErrorContainer
+}
+func (this *ClaimStreamEntriesResponse) JsonBytes() ([]byte, error) {
+	return json.Marshal(this)
+}
+type AckStreamEntriesResponse struct {
+	Acked int64 `json:"acked"`
+	ErrorContainer
+}
+func (this *AckStreamEntriesResponse) JsonBytes() ([]byte, error) {
+	return json.Marshal(this)
+}
+
+
+// A page of a collection's members, which are strings for lists and sets,
+// ZsetVals for sorted sets and HashVals for hashes. The cursor is given back
+// to get the next page, and is "0" once there are no more. A page can have
+// no members while there are still more to come.
+type CollectionPage struct {
+	Type string `json:"type"`
+	// The number of members in the whole collection
+	Count int64 `json:"count"`
+	Members interface{} `json:"members"`
+	// Set if the members hold binary data, all of which are then encoded
+	Encoding string `json:"encoding,omitempty"`
+	Cursor string `json:"cursor"`
+}
+type CollectionPageResponse struct {
+	CollectionPage
+	ErrorContainer
+}
+func (this *CollectionPageResponse) JsonBytes() ([]byte, error) {
+	return json.Marshal(this)
+}
+
+
+type DecodersResponse struct {
+	// The names of every registered decoder, besides auto and none
+	Decoders []string `json:"decoders"`
+	ErrorContainer
+}
+func (this *DecodersResponse) JsonBytes() ([]byte, error) {
+	return json.Marshal(this)
+}
 
 
 type ConnectionsResponse struct {
 	Connections []*Connection `json:"connections"`
+	// While locked, the connections are returned without their secrets
+	Locked bool `json:"locked,omitempty"`
+	// Also sent as the ETag header, for use in If-Match when editing
+	Revision string `json:"revision,omitempty"`
 	ErrorContainer
 }
 func (this *ConnectionsResponse) JsonBytes() ([]byte, error) {
@@ -70,12 +277,177 @@ func (this *ConnectionsResponse) JsonBytes() ([]byte, error) {
 }
 
 
-type DeleteResponse struct {
+type LockStatusResponse struct {
+	MasterPasswordEnabled bool `json:"masterPasswordEnabled"`
+	Locked bool `json:"locked"`
+	ErrorContainer
+}
+func (this *LockStatusResponse) JsonBytes() ([]byte, error) {
+	return json.Marshal(this)
+}
+
+
+type ConnectionsVersionsResponse struct {
+	// Newest first
+	Versions []*ConnectionsVersion `json:"versions"`
+	ErrorContainer
+}
+type ConnectionsVersion struct {
+	Id string `json:"id"`
+	// When this version was replaced, in RFC 3339 format
+	SavedAt string `json:"savedAt"`
+	ConnectionCount int `json:"connectionCount"`
+}
+func (this *ConnectionsVersionsResponse) JsonBytes() ([]byte, error) {
+	return json.Marshal(this)
+}
+
+
+type ConnectionUriResponse struct {
+	Uri string `json:"uri"`
+	ErrorContainer
+}
+func (this *ConnectionUriResponse) JsonBytes() ([]byte, error) {
+	return json.Marshal(this)
+}
+
+
+type TestConnectionResponse struct {
+	Node string `json:"node,omitempty"`
+	ErrorContainer
+}
+func (this *TestConnectionResponse) JsonBytes() ([]byte, error) {
+	return json.Marshal(this)
+}
+
+
+// TTL filter values
+const (
+	TtlWith = "with"
+	TtlWithout = "without"
+)
+
+// Narrows a pattern delete to keys of one type, keys with or without a TTL or
+// with a TTL in a range, and keys that haven't been accessed for a time. TTLs
+// and idle times are in seconds, and blank or zero fields don't filter.
+type KeyFilter struct {
+	Type string `json:"type,omitempty"`
+	Ttl string `json:"ttl,omitempty"`
+	// Setting either bound only matches keys that have a TTL
+	MinTtl int64 `json:"minTtl,omitempty"`
+	MaxTtl int64 `json:"maxTtl,omitempty"`
+	MinIdle int64 `json:"minIdle,omitempty"`
+}
+
+// The number of keys matching the pattern that each filter excluded. When
+// the server filters by type while scanning, the keys it leaves out aren't
+// seen, so they aren't counted.
+type FilterCounts struct {
+	Type int `json:"type"`
+	Ttl int `json:"ttl"`
+	Idle int `json:"idle"`
+	TypeFilteredByServer bool `json:"typeFilteredByServer,omitempty"`
+}
+
+// What a pattern delete would remove. The confirmation token must be given
+// to carry out the delete.
+type DeletePreview struct {
 	Count int `json:"count"`
-	DeletedAllKeys bool `json:"deletedAllKeys"`
+	SampleKeys []string `json:"sampleKeys"`
+	SampleKeysEncoding string `json:"sampleKeysEncoding,omitempty"`
+	// The number of keys of each type
+	Types map[string]int `json:"types"`
+	Filter *KeyFilter `json:"filter,omitempty"`
+	FilterCounts *FilterCounts `json:"filterCounts,omitempty"`
+	Confirmation *Confirmation `json:"confirmation,omitempty"`
+}
+type DeletePreviewResponse struct {
+	DeletePreview
+	ErrorContainer
+}
+func (this *DeletePreviewResponse) JsonBytes() ([]byte, error) {
+	return json.Marshal(this)
+}
+
+
+// Job states
+const (
+	JobRunning = "running"
+	JobCompleted = "completed"
+	JobFailed = "failed"
+	JobCancelled = "cancelled"
+)
+
+// A pattern delete running on the server. The rate is of keys deleted per
+// second, and the ETA in seconds is only known when the delete was previewed.
+type DeleteJob struct {
+	Id int `json:"id"`
+	ConnName string `json:"connName"`
+	Pattern string `json:"pattern"`
+	PatternEncoding string `json:"patternEncoding,omitempty"`
+	Filter *KeyFilter `json:"filter,omitempty"`
+	State string `json:"state"`
+	Scanned int `json:"scanned"`
+	Deleted int `json:"deleted"`
+	DeletedAllKeys bool `json:"deletedAllKeys,omitempty"`
+	ExpectedCount int `json:"expectedCount,omitempty"`
+	// Only for jobs that back up the keys first
+	BackupId string `json:"backupId,omitempty"`
+	BackedUp int `json:"backedUp,omitempty"`
+	FilterCounts *FilterCounts `json:"filterCounts,omitempty"`
+	Errors int `json:"errors"`
+	LastError string `json:"lastError,omitempty"`
+	Rate float64 `json:"rate"`
+	EtaSeconds float64 `json:"etaSeconds,omitempty"`
+	StartedAt string `json:"startedAt"`
+	FinishedAt string `json:"finishedAt,omitempty"`
+}
+type DeleteJobResponse struct {
+	Job *DeleteJob `json:"job"`
+	ErrorContainer
+}
+func (this *DeleteJobResponse) JsonBytes() ([]byte, error) {
+	return json.Marshal(this)
+}
+type DeleteJobsResponse struct {
+	Jobs []*DeleteJob `json:"jobs"`
 	ErrorContainer
 }
-func (this *DeleteResponse) JsonBytes() ([]byte, error) {
+func (this *DeleteJobsResponse) JsonBytes() ([]byte, error) {
+	return json.Marshal(this)
+}
+
+
+// A backup of keys taken before they were deleted. It's incomplete if the
+// delete failed or was cancelled partway, in which case it holds the keys
+// that were deleted before then.
+type Backup struct {
+	Id string `json:"id"`
+	ConnName string `json:"connName"`
+	Pattern string `json:"pattern"`
+	PatternEncoding string `json:"patternEncoding,omitempty"`
+	CreatedAt string `json:"createdAt"`
+	KeyCount int `json:"keyCount"`
+	Complete bool `json:"complete"`
+	// The size of the backup file in bytes
+	Size int64 `json:"size"`
+}
+type BackupsResponse struct {
+	Backups []*Backup `json:"backups"`
+	ErrorContainer
+}
+func (this *BackupsResponse) JsonBytes() ([]byte, error) {
+	return json.Marshal(this)
+}
+type RestoreBackupResponse struct {
+	Restored int `json:"restored"`
+	// Keys that already existed, when not replacing them
+	Skipped int `json:"skipped"`
+	// Keys whose expiry passed since the backup was taken
+	Expired int `json:"expired"`
+	ErrorContainer
+}
+func (this *RestoreBackupResponse) JsonBytes() ([]byte, error) {
 	return json.Marshal(this)
 }
 
@@ -85,4 +457,30 @@ type ErrorContainer struct {
 }
 type ErrorResponse struct {
 	Message string `json:"message"`
+	Code string `json:"code,omitempty"`
+	Fields []*FieldError `json:"fields,omitempty"`
+	// Set when the request has to be repeated with a confirmation token
+	Confirmation *Confirmation `json:"confirmation,omitempty"`
+}
+// A token for one operation on a protected connection. The connection name
+// and pattern are echoed so the UI can show what's being confirmed. There's
+// no token when the operation has to be previewed to get one.
+type Confirmation struct {
+	Token string `json:"token,omitempty"`
+	ConnName string `json:"connName"`
+	Operation string `json:"operation"`
+	Pattern string `json:"pattern"`
+	PatternEncoding string `json:"patternEncoding,omitempty"`
+	Filter *KeyFilter `json:"filter,omitempty"`
+	// The number of keys a previewed delete found
+	KeyCount int `json:"keyCount,omitempty"`
+	ExpiresAt string `json:"expiresAt,omitempty"`
+}
+// A problem with one field of a connection in an upsert request. Index is the
+// position of the connection in the request, and Field is its JSON path, such
+// as "port" or "ssh.host".
+type FieldError struct {
+	Index int `json:"index"`
+	Field string `json:"field"`
+	Message string `json:"message"`
 }
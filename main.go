@@ -1,14 +1,20 @@
 package main
 
 import (
+	"bufio"
 	"flag"
+	"fmt"
 	"log"
 	"net/http"
+	"os"
+	"strings"
 	
 	"github.com/gorilla/mux"
 	"github.com/rs/cors"
 	glogging "github.com/op/go-logging"
 	
+	"github.com/bencase/revis-service/config"
+	rconns "github.com/bencase/revis-service/connections"
 	rserver "github.com/bencase/revis-service/server"
 )
 
@@ -16,18 +22,47 @@ const pathPrefix = "/api"
 const redisPathPrefix = "/redis"
 
 const portFlag = "port"
+const dataDirFlag = "data-dir"
+
+// Subcommands that run against the connection store and exit instead of serving
+const rotateKeyCmd = "rotate-key"
+const rollBackKeyCmd = "rollback-key"
+
+// Read by rotate-key so that it can run without a prompt
+const masterPasswordEnvVar = "REVIS_MASTER_PASSWORD"
 
 var logger = glogging.MustGetLogger("main")
 
 var port = "63799"
+var dataDir = ""
 
 func init() {
 	flag.StringVar(&port, portFlag, "63799", "the port on which to start the server")
+	flag.StringVar(&dataDir, dataDirFlag, "",
+		"the directory for connections and keys (overrides $" + config.DataDirEnvVar + ")")
+	flag.Usage = func() {
+		fmt.Fprintf(flag.CommandLine.Output(), "Usage: %s [flags] [%s | %s]\n",
+			os.Args[0], rotateKeyCmd, rollBackKeyCmd)
+		flag.PrintDefaults()
+	}
 	flag.Parse()
 }
 
 func main() {
 	
+	err := config.InitPaths(dataDir)
+	if err != nil {
+		log.Fatalln("Error setting up data directory:", err)
+	}
+	err = rconns.InitStore()
+	if err != nil {
+		log.Fatalln("Error setting up connection store:", err)
+	}
+	if flag.NArg() > 0 {
+		runCommand(flag.Arg(0))
+		return
+	}
+	
 	server, err := rserver.NewRedisServer()
 	if err != nil {
 		log.Fatalln("Error getting server instance:", err)
@@ -47,6 +82,37 @@ func main() {
 			server.DeleteConnections).
 		Methods("DELETE")
 
+	r.HandleFunc(pathPrefix + redisPathPrefix + "/connections/versions",
+			server.GetConnectionsVersions).
+		Methods("GET")
+	r.HandleFunc(pathPrefix + redisPathPrefix + "/connections/versions/restore",
+			server.RestoreConnectionsVersion).
+		Methods("POST")
+
+	r.HandleFunc(pathPrefix + redisPathPrefix + "/connections/uri",
+			server.ImportConnectionUri).
+		Methods("POST")
+	r.HandleFunc(pathPrefix + redisPathPrefix + "/connections/uri",
+			server.ExportConnectionUri).
+		Methods("GET")
+
+	r.HandleFunc(pathPrefix + redisPathPrefix + "/connections/lock",
+			server.GetLockStatus).
+		Methods("GET")
+	r.HandleFunc(pathPrefix + redisPathPrefix + "/connections/lock",
+			server.LockConnections).
+		Methods("POST")
+	r.HandleFunc(pathPrefix + redisPathPrefix + "/connections/unlock",
+			server.UnlockConnections).
+		Methods("POST")
+	r.HandleFunc(pathPrefix + redisPathPrefix + "/connections/master-password",
+			server.SetMasterPassword).
+		Methods("POST")
+
+	r.HandleFunc(pathPrefix + redisPathPrefix + "/connections/rotate-key",
+			server.RotateKey).
+		Methods("POST")
+
 	r.HandleFunc(pathPrefix + redisPathPrefix + "/connections/test",
 			server.TestConnection).
 		Methods("POST")
@@ -54,17 +120,72 @@ func main() {
 	r.HandleFunc(pathPrefix + redisPathPrefix + "/kvs",
 			server.GetKeysWithValues).
 		Methods("GET")
+	r.HandleFunc(pathPrefix + redisPathPrefix + "/kvs/members",
+			server.GetCollectionPage).
+		Methods("GET")
+	r.HandleFunc(pathPrefix + redisPathPrefix + "/decoders",
+			server.GetDecoders).
+		Methods("GET")
 	
 	r.HandleFunc(pathPrefix + redisPathPrefix + "/keys",
 			server.DeleteKeysMatchingPattern).
 		Methods("DELETE")
+	r.HandleFunc(pathPrefix + redisPathPrefix + "/keys/jobs",
+			server.GetDeleteJobs).
+		Methods("GET")
+	r.HandleFunc(pathPrefix + redisPathPrefix + "/keys/jobs/{" + rserver.JobIdVar + "}",
+			server.GetDeleteJob).
+		Methods("GET")
+	r.HandleFunc(pathPrefix + redisPathPrefix + "/keys/jobs/{" + rserver.JobIdVar + "}",
+			server.CancelDeleteJob).
+		Methods("DELETE")
+	
+	r.HandleFunc(pathPrefix + redisPathPrefix + "/backups",
+			server.GetBackups).
+		Methods("GET")
+	r.HandleFunc(pathPrefix + redisPathPrefix + "/backups/restore",
+			server.RestoreBackup).
+		Methods("POST")
+	r.HandleFunc(pathPrefix + redisPathPrefix + "/backups/{" + rserver.BackupIdVar + "}",
+			server.DeleteBackup).
+		Methods("DELETE")
+	
+	r.HandleFunc(pathPrefix + redisPathPrefix + "/streams/groups",
+			server.GetStreamGroups).
+		Methods("GET")
+	r.HandleFunc(pathPrefix + redisPathPrefix + "/streams/groups",
+			server.CreateStreamGroup).
+		Methods("POST")
+	r.HandleFunc(pathPrefix + redisPathPrefix + "/streams/groups",
+			server.DestroyStreamGroup).
+		Methods("DELETE")
+	r.HandleFunc(pathPrefix + redisPathPrefix + "/streams/groups/setid",
+			server.SetStreamGroupId).
+		Methods("POST")
+	r.HandleFunc(pathPrefix + redisPathPrefix + "/streams/consumers",
+			server.GetStreamConsumers).
+		Methods("GET")
+	r.HandleFunc(pathPrefix + redisPathPrefix + "/streams/pending",
+			server.GetPendingStreamEntries).
+		Methods("GET")
+	r.HandleFunc(pathPrefix + redisPathPrefix + "/streams/claim",
+			server.ClaimStreamEntries).
+		Methods("POST")
+	r.HandleFunc(pathPrefix + redisPathPrefix + "/streams/ack",
+			server.AckStreamEntries).
+		Methods("POST")
 	
 	corsOpts := cors.New(cors.Options{
 		AllowedOrigins: []string{"*"},
 		AllowedMethods: []string{"HEAD", "GET", "POST", "OPTIONS"},
 		AllowedHeaders: []string{rserver.ConnNameHeader,
 			rserver.PatternHeader,
-			rserver.ScanIdHeader},
+			rserver.KeyHeader,
+			rserver.KeyEncodingHeader,
+			rserver.ScanIdHeader,
+			rserver.AuthorizationHeader,
+			rserver.IfMatchHeader,
+			rserver.ConfirmTokenHeader},
 	})
 	handler := corsOpts.Handler(r)
 	http.Handle("/", handler)
@@ -74,3 +195,44 @@ func main() {
 		panic(err)
 	}
 }
+
+
+func runCommand(cmd string) {
+	switch cmd {
+	case rotateKeyCmd :
+		masterPassword := ""
+		if rconns.MasterPasswordEnabled() {
+			masterPassword = readMasterPassword()
+			err := rconns.UnlockStore(masterPassword)
+			if err != nil {
+				log.Fatalln("Error unlocking connection store:", err)
+			}
+		}
+		err := rconns.RotateKey(masterPassword)
+		if err != nil {
+			log.Fatalln("Error rotating encryption key:", err)
+		}
+		fmt.Println("Rotated encryption key")
+	case rollBackKeyCmd :
+		err := rconns.RollBackKeyRotation()
+		if err != nil {
+			log.Fatalln("Error rolling back key rotation:", err)
+		}
+		fmt.Println("Restored encryption key and connections from backup")
+	default :
+		flag.Usage()
+		os.Exit(2)
+	}
+}
+
+func readMasterPassword() string {
+	if password := os.Getenv(masterPasswordEnvVar); password != "" {
+		return password
+	}
+	fmt.Print("Master password: ")
+	line, err := bufio.NewReader(os.Stdin).ReadString('\n')
+	if err != nil && line == "" {
+		log.Fatalln("Error reading master password:", err)
+	}
+	return strings.TrimRight(line, "\r\n")
+}
\ No newline at end of file
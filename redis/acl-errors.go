@@ -0,0 +1,63 @@
+package redis
+
+import (
+	"strings"
+)
+
+// Error codes returned by Redis 6+ when an ACL check fails
+const (
+	AclCodeWrongPass = "WRONGPASS"
+	AclCodeNoPerm = "NOPERM"
+	AclCodeNoAuth = "NOAUTH"
+	AclCodeUsernameUnsupported = "USERNAMEUNSUPPORTED"
+)
+
+// AclError is an authentication or authorization failure reported by the
+// server, with a message that can be shown to the user as-is.
+type AclError struct {
+	Code string
+	Message string
+	ServerMessage string
+}
+func (this *AclError) Error() string {
+	return this.Message
+}
+
+// If the error returned by Redis is an ACL failure, returns an AclError for it.
+// Any other error is returned unchanged.
+func ToAclError(err error) error {
+	if err == nil {
+		return nil
+	}
+	if _, isAclErr := err.(*AclError); isAclErr {
+		return err
+	}
+	serverMsg := err.Error()
+	switch {
+	case strings.HasPrefix(serverMsg, AclCodeWrongPass):
+		return &AclError{Code: AclCodeWrongPass,
+			Message: "Authentication failed: the username or password is incorrect, or the user is disabled",
+			ServerMessage: serverMsg}
+	case strings.HasPrefix(serverMsg, AclCodeNoPerm):
+		return &AclError{Code: AclCodeNoPerm,
+			Message: "Permission denied by the server's ACL rules: " +
+				strings.TrimSpace(strings.TrimPrefix(serverMsg, AclCodeNoPerm)),
+			ServerMessage: serverMsg}
+	case strings.HasPrefix(serverMsg, AclCodeNoAuth):
+		return &AclError{Code: AclCodeNoAuth,
+			Message: "The server requires authentication, but no password was provided",
+			ServerMessage: serverMsg}
+	}
+	return err
+}
+
+// Servers older than Redis 6 reject AUTH with two arguments, which would otherwise
+// surface as a confusing "wrong number of arguments" error.
+func getAuthError(err error, withUsername bool) error {
+	if withUsername && strings.Contains(err.Error(), "wrong number of arguments") {
+		return &AclError{Code: AclCodeUsernameUnsupported,
+			Message: "The server does not support ACL usernames (Redis 6 or later is required)",
+			ServerMessage: err.Error()}
+	}
+	return ToAclError(err)
+}
\ No newline at end of file
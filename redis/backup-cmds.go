@@ -0,0 +1,244 @@
+package redis
+
+import (
+	"errors"
+	"strings"
+	"time"
+
+	"github.com/mediocregopher/radix.v2/redis"
+
+	"github.com/bencase/revis-service/dto"
+	ki "github.com/bencase/revis-service/redis/keyiterator"
+)
+
+// The error RESTORE gives when the key already exists and REPLACE wasn't given
+const busyKeyPrefix = "BUSYKEY"
+
+// Like DeleteKeysMatchingPattern, but each batch of keys is dumped to the
+// backup before it's unlinked, and only the keys that were dumped are
+// unlinked. Unlike a plain delete, it stops at the first error, since keys
+// that can't be backed up mustn't be deleted. Keys the filter excludes are
+// neither backed up nor deleted.
+func (this *iRedisCmdRunner) BackUpAndDeleteKeysMatchingPattern(pattern string, filter *dto.KeyFilter,
+		status *deleteStatus, backup *backupWriter) error {
+	keyIterator, typeFilteredByServer, err := this.newFilteredKeyIterator(pattern, filter)
+	if err != nil {
+		status.setError(err)
+		return err
+	}
+	defer keyIterator.Close()
+	batch := make([]string, 0, unlinkChunkSize)
+	for keyIterator.HasNext() && !status.isCancelled() {
+		key, err := keyIterator.Next()
+		if err == ki.NoMoreElements {
+			break
+		}
+		if err != nil {
+			status.setError(err)
+			return err
+		}
+		status.addScanned(1)
+		batch = append(batch, key.Key)
+		if len(batch) >= unlinkChunkSize {
+			err = this.filterAndBackUpAndDeleteKeys(batch, filter, typeFilteredByServer, status, backup)
+			if err != nil { return err }
+			batch = make([]string, 0, unlinkChunkSize)
+		}
+	}
+	if len(batch) > 0 && !status.isCancelled() {
+		return this.filterAndBackUpAndDeleteKeys(batch, filter, typeFilteredByServer, status, backup)
+	}
+	return nil
+}
+func (this *iRedisCmdRunner) filterAndBackUpAndDeleteKeys(keys []string, filter *dto.KeyFilter,
+		typeFilteredByServer bool, status *deleteStatus, backup *backupWriter) error {
+	keys, counts, err := this.filterKeys(keys, filter, typeFilteredByServer)
+	if err != nil {
+		status.setError(err)
+		return err
+	}
+	if filter != nil {
+		status.addFiltered(counts)
+	}
+	if len(keys) == 0 {
+		return nil
+	}
+	return this.backUpAndDeleteKeys(keys, status, backup)
+}
+func (this *iRedisCmdRunner) backUpAndDeleteKeys(keys []string, status *deleteStatus,
+		backup *backupWriter) error {
+	entries, err := this.dumpKeys(keys)
+	// If a cluster's slots have moved, refresh the mapping and try once more
+	if isRedirectError(err) {
+		err = this.nodes.refresh()
+		if err == nil {
+			entries, err = this.dumpKeys(keys)
+		}
+	}
+	if err == nil {
+		err = backup.write(entries)
+	}
+	if err != nil {
+		status.setError(err)
+		return err
+	}
+	status.addBackedUp(len(entries))
+
+	dumpedKeys := make([]string, len(entries))
+	for i, entry := range entries {
+		dumpedKeys[i] = string(entry.Key)
+	}
+	count, err := this.unlinkKeys(dumpedKeys)
+	if isRedirectError(err) {
+		err = this.nodes.refresh()
+		if err == nil {
+			var retryCount int
+			retryCount, err = this.unlinkKeys(dumpedKeys)
+			count += retryCount
+		}
+	}
+	status.addCount(count)
+	if err != nil {
+		status.setError(err)
+	}
+	return err
+}
+
+func (this *iRedisCmdRunner) dumpKeys(keys []string) ([]*backupEntry, error) {
+	keysByNode := make(map[connPool][]string)
+	for _, key := range keys {
+		pool, err := this.nodes.nodeForKey(key)
+		if err != nil { return nil, err }
+		keysByNode[pool] = append(keysByNode[pool], key)
+	}
+	entries := make([]*backupEntry, 0, len(keys))
+	for pool, nodeKeys := range keysByNode {
+		nodeEntries, err := dumpKeysOnNode(pool, nodeKeys)
+		if err != nil { return nil, err }
+		entries = append(entries, nodeEntries...)
+	}
+	return entries, nil
+}
+func dumpKeysOnNode(pool connPool, keys []string) ([]*backupEntry, error) {
+	conn, err := pool.Get()
+	if err != nil { return nil, err }
+	defer pool.Put(conn)
+	// Taken before the PTTLs, so that no expiry is put later than it was
+	dumpedAt := time.Now().UnixNano() / int64(time.Millisecond)
+	for _, key := range keys {
+		conn.PipeAppend("PTTL", key)
+		conn.PipeAppend("DUMP", key)
+	}
+	resps, err := getResponsesFromPipeline(conn)
+	if err != nil { return nil, err }
+	entries := make([]*backupEntry, 0, len(keys))
+	for i, key := range keys {
+		pttl, err := resps[i*2].Int64()
+		if err != nil { return nil, err }
+		dumpResp := resps[i*2+1]
+		// The key expired or was deleted since it was scanned
+		if dumpResp.IsType(redis.Nil) {
+			continue
+		}
+		value, err := dumpResp.Bytes()
+		if err != nil { return nil, err }
+		entry := &backupEntry{Key: []byte(key), Value: value}
+		// PTTL is negative for a key without an expiry
+		if pttl >= 0 {
+			entry.ExpiresAt = dumpedAt + pttl
+		}
+		entries = append(entries, entry)
+	}
+	return entries, nil
+}
+
+// Restores the entries with RESTORE. Keys that already exist are replaced if
+// replace is true, and skipped otherwise. Entries whose expiry has passed are
+// skipped as well.
+func (this *iRedisCmdRunner) RestoreKeys(entries []*backupEntry, replace bool) (*restoreCounts,
+		error) {
+	counts := &restoreCounts{}
+	toRestore := make([]*backupEntry, 0, len(entries))
+	ttls := make(map[*backupEntry]int64)
+	now := time.Now().UnixNano() / int64(time.Millisecond)
+	for _, entry := range entries {
+		ttl := int64(0)
+		if entry.ExpiresAt > 0 {
+			ttl = entry.ExpiresAt - now
+			if ttl <= 0 {
+				counts.expired++
+				continue
+			}
+		}
+		ttls[entry] = ttl
+		toRestore = append(toRestore, entry)
+	}
+	redirected, err := this.restoreOnNodes(toRestore, ttls, replace, counts)
+	// If a cluster's slots have moved, refresh the mapping and retry the keys that moved
+	if err == nil && len(redirected) > 0 {
+		err = this.nodes.refresh()
+		if err == nil {
+			redirected, err = this.restoreOnNodes(redirected, ttls, replace, counts)
+		}
+		if err == nil && len(redirected) > 0 {
+			err = errors.New("Some keys were still redirected after refreshing the cluster's slots")
+		}
+	}
+	return counts, err
+}
+type restoreCounts struct {
+	restored int
+	skipped int
+	expired int
+}
+// Returns the entries that a node answered were on another node.
+func (this *iRedisCmdRunner) restoreOnNodes(entries []*backupEntry, ttls map[*backupEntry]int64,
+		replace bool, counts *restoreCounts) ([]*backupEntry, error) {
+	entriesByNode := make(map[connPool][]*backupEntry)
+	for _, entry := range entries {
+		pool, err := this.nodes.nodeForKey(string(entry.Key))
+		if err != nil { return nil, err }
+		entriesByNode[pool] = append(entriesByNode[pool], entry)
+	}
+	redirected := make([]*backupEntry, 0)
+	for pool, nodeEntries := range entriesByNode {
+		nodeRedirected, err := restoreOnNode(pool, nodeEntries, ttls, replace, counts)
+		if err != nil { return nil, err }
+		redirected = append(redirected, nodeRedirected...)
+	}
+	return redirected, nil
+}
+func restoreOnNode(pool connPool, entries []*backupEntry, ttls map[*backupEntry]int64,
+		replace bool, counts *restoreCounts) ([]*backupEntry, error) {
+	conn, err := pool.Get()
+	if err != nil { return nil, err }
+	defer pool.Put(conn)
+	for _, entry := range entries {
+		args := []interface{}{entry.Key, ttls[entry], entry.Value}
+		if replace {
+			args = append(args, "REPLACE")
+		}
+		conn.PipeAppend("RESTORE", args...)
+	}
+	redirected := make([]*backupEntry, 0)
+	for _, entry := range entries {
+		resp := conn.PipeResp()
+		if resp.IsType(redis.IOErr) {
+			conn.PipeClear()
+			return nil, resp.Err
+		}
+		switch {
+		case resp.Err == nil :
+			counts.restored++
+		case strings.HasPrefix(resp.Err.Error(), busyKeyPrefix) :
+			counts.skipped++
+		case isRedirectError(resp.Err) :
+			redirected = append(redirected, entry)
+		default :
+			// Any other error, such as a payload from an incompatible version, fails the restore
+			conn.PipeClear()
+			return nil, resp.Err
+		}
+	}
+	return redirected, nil
+}
\ No newline at end of file
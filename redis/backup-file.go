@@ -0,0 +1,201 @@
+package redis
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v2"
+
+	"github.com/bencase/revis-service/config"
+	"github.com/bencase/revis-service/dto"
+	"github.com/bencase/revis-service/util"
+)
+
+// Backups are kept in this directory under the data directory. Each has a
+// file of keys, one JSON object per line, and a metadata file beside it.
+const backupsDirName = "backups"
+const backupKeysSuffix = ".jsonl"
+const backupMetaSuffix = ".yml"
+
+var BackupNotFoundError = errors.New("Could not find a backup with that ID")
+
+type backupMeta struct {
+	Id string `yaml:"id"`
+	ConnName string `yaml:"connName"`
+	Pattern string `yaml:"pattern"`
+	// In milliseconds since the epoch
+	CreatedAt int64 `yaml:"createdAt"`
+	KeyCount int `yaml:"keyCount"`
+	// False while the backup is being written, or if writing it was interrupted
+	Complete bool `yaml:"complete"`
+}
+
+// A key as returned by DUMP, along with when it expires in milliseconds since
+// the epoch, which is 0 for a key without an expiry. Keys and values are
+// binary, so they're base64-encoded in the JSON.
+type backupEntry struct {
+	Key []byte `json:"k"`
+	ExpiresAt int64 `json:"e,omitempty"`
+	Value []byte `json:"v"`
+}
+
+// Writes a backup. The keys hold the data itself, unencrypted, so the files
+// are only readable by the current user.
+type backupWriter struct {
+	meta *backupMeta
+	file *os.File
+	bufWriter *bufio.Writer
+}
+
+func newBackupWriter(connName string, pattern string) (*backupWriter, error) {
+	err := os.MkdirAll(getBackupsDir(), 0700)
+	if err != nil { return nil, err }
+	now := time.Now()
+	meta := &backupMeta{Id: strconv.FormatInt(now.UnixNano(), 10),
+		ConnName: connName,
+		Pattern: pattern,
+		CreatedAt: now.UnixNano() / int64(time.Millisecond)}
+	file, err := os.OpenFile(getBackupKeysPath(meta.Id), os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0600)
+	if err != nil { return nil, err }
+	writer := &backupWriter{meta: meta, file: file, bufWriter: bufio.NewWriter(file)}
+	err = writeBackupMeta(meta)
+	if err != nil {
+		file.Close()
+		return nil, err
+	}
+	return writer, nil
+}
+
+// Writes the entries and syncs them to disk, so that the keys can safely be
+// deleted once this returns.
+func (this *backupWriter) write(entries []*backupEntry) error {
+	encoder := json.NewEncoder(this.bufWriter)
+	for _, entry := range entries {
+		err := encoder.Encode(entry)
+		if err != nil { return err }
+	}
+	err := this.bufWriter.Flush()
+	if err != nil { return err }
+	err = this.file.Sync()
+	if err != nil { return err }
+	this.meta.KeyCount += len(entries)
+	return nil
+}
+
+// Marks the backup complete if nothing went wrong writing it.
+func (this *backupWriter) close(complete bool) error {
+	err := this.file.Close()
+	if err != nil { return err }
+	this.meta.Complete = complete
+	return writeBackupMeta(this.meta)
+}
+
+// Calls the function with each batch of entries in the backup.
+func readBackup(id string, batchSize int, fn func(meta *backupMeta, entries []*backupEntry) error) error {
+	meta, err := readBackupMeta(id)
+	if err != nil { return err }
+	file, err := os.Open(getBackupKeysPath(id))
+	if err != nil { return err }
+	defer file.Close()
+	decoder := json.NewDecoder(bufio.NewReader(file))
+	entries := make([]*backupEntry, 0, batchSize)
+	for decoder.More() {
+		entry := &backupEntry{}
+		err = decoder.Decode(entry)
+		if err != nil { return err }
+		entries = append(entries, entry)
+		if len(entries) >= batchSize {
+			err = fn(meta, entries)
+			if err != nil { return err }
+			entries = make([]*backupEntry, 0, batchSize)
+		}
+	}
+	if len(entries) > 0 {
+		return fn(meta, entries)
+	}
+	return nil
+}
+
+// Lists the backups, newest first.
+func listBackups() ([]*dto.Backup, error) {
+	files, err := ioutil.ReadDir(getBackupsDir())
+	if os.IsNotExist(err) {
+		return []*dto.Backup{}, nil
+	}
+	if err != nil { return nil, err }
+	backups := make([]*dto.Backup, 0)
+	for _, file := range files {
+		if !strings.HasSuffix(file.Name(), backupMetaSuffix) {
+			continue
+		}
+		meta, err := readBackupMeta(strings.TrimSuffix(file.Name(), backupMetaSuffix))
+		if err != nil {
+			logger.Warning("Could not read backup metadata", file.Name() + ":", err)
+			continue
+		}
+		backup := &dto.Backup{Id: meta.Id,
+			ConnName: meta.ConnName,
+			Pattern: meta.Pattern,
+			CreatedAt: time.Unix(0, meta.CreatedAt * int64(time.Millisecond)).UTC().Format(time.RFC3339),
+			KeyCount: meta.KeyCount,
+			Complete: meta.Complete}
+		backup.PatternEncoding = encodeIfBinary(&backup.Pattern)
+		if keysInfo, err := os.Stat(getBackupKeysPath(meta.Id)); err == nil {
+			backup.Size = keysInfo.Size()
+		}
+		backups = append(backups, backup)
+	}
+	sort.Slice(backups, func(i, j int) bool { return backups[i].Id > backups[j].Id })
+	return backups, nil
+}
+
+func deleteBackup(id string) error {
+	if !isValidBackupId(id) || !util.FileExists(getBackupMetaPath(id)) {
+		return BackupNotFoundError
+	}
+	err := os.Remove(getBackupKeysPath(id))
+	if err != nil && !os.IsNotExist(err) { return err }
+	return os.Remove(getBackupMetaPath(id))
+}
+
+func readBackupMeta(id string) (*backupMeta, error) {
+	if !isValidBackupId(id) {
+		return nil, BackupNotFoundError
+	}
+	inBytes, err := ioutil.ReadFile(getBackupMetaPath(id))
+	if os.IsNotExist(err) {
+		return nil, BackupNotFoundError
+	}
+	if err != nil { return nil, err }
+	meta := &backupMeta{}
+	err = yaml.Unmarshal(inBytes, meta)
+	return meta, err
+}
+func writeBackupMeta(meta *backupMeta) error {
+	outBytes, err := yaml.Marshal(meta)
+	if err != nil { return err }
+	return util.WriteFileAtomic(getBackupMetaPath(meta.Id), outBytes, 0600)
+}
+
+// IDs are used in file paths, so only the digits they're made of are allowed
+func isValidBackupId(id string) bool {
+	_, err := strconv.ParseUint(id, 10, 64)
+	return err == nil
+}
+
+func getBackupsDir() string {
+	return config.DataPath + backupsDirName
+}
+func getBackupKeysPath(id string) string {
+	return getBackupsDir() + string(os.PathSeparator) + id + backupKeysSuffix
+}
+func getBackupMetaPath(id string) string {
+	return getBackupsDir() + string(os.PathSeparator) + id + backupMetaSuffix
+}
\ No newline at end of file
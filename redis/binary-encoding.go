@@ -0,0 +1,104 @@
+package redis
+
+import (
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"unicode/utf8"
+
+	"github.com/bencase/revis-service/dto"
+)
+
+var InvalidEncodingError = errors.New("The encoding must be " + dto.EncodingBase64 + " or " +
+	dto.EncodingHex)
+var UndecodableStringError = errors.New("The key or pattern doesn't match its encoding")
+
+// Decodes a key name or pattern given in a request. A blank encoding means
+// it's plain text.
+func DecodeString(str string, encoding string) (string, error) {
+	switch encoding {
+	case "" : return str, nil
+	case dto.EncodingBase64 :
+		bytes, err := base64.StdEncoding.DecodeString(str)
+		if err != nil { return "", UndecodableStringError }
+		return string(bytes), nil
+	case dto.EncodingHex :
+		bytes, err := hex.DecodeString(str)
+		if err != nil { return "", UndecodableStringError }
+		return string(bytes), nil
+	}
+	return "", InvalidEncodingError
+}
+
+// If any of the strings isn't valid UTF-8, and so would be mangled in JSON,
+// all of them are base64 encoded in place so that they can be told apart
+// by the one encoding. Returns the encoding used, or blank if none was.
+func encodeIfBinary(strs ...*string) string {
+	isBinary := false
+	for _, str := range strs {
+		if !utf8.ValidString(*str) {
+			isBinary = true
+			break
+		}
+	}
+	if !isBinary {
+		return ""
+	}
+	for _, str := range strs {
+		*str = base64.StdEncoding.EncodeToString([]byte(*str))
+	}
+	return dto.EncodingBase64
+}
+
+// Encodes the key's name and value separately, once everything that needs
+// the name as it is has been done.
+func encodeBinaryKeys(keys []*dto.Key) {
+	for _, key := range keys {
+		key.KeyEncoding = encodeIfBinary(&key.Key)
+		if str, isStr := key.Val.(string); isStr {
+			key.ValEncoding = encodeIfBinary(&str)
+			key.Val = str
+		} else {
+			key.ValEncoding = encodeIfBinary(getStrsOfVal(key.Val)...)
+		}
+	}
+}
+
+// Returns a copy of the confirmation with its pattern encoded if it's binary,
+// leaving the stored one to be matched against the raw pattern.
+func encodeConfirmation(confirmation *dto.Confirmation) *dto.Confirmation {
+	encoded := *confirmation
+	encoded.PatternEncoding = encodeIfBinary(&encoded.Pattern)
+	return &encoded
+}
+
+// Returns pointers to every string within a value that isn't itself a string.
+func getStrsOfVal(val interface{}) []*string {
+	var strs []*string
+	switch typedVal := val.(type) {
+	case []string :
+		for i := range typedVal {
+			strs = append(strs, &typedVal[i])
+		}
+	case []*dto.ZsetVal :
+		for _, zval := range typedVal {
+			strs = append(strs, &zval.Zval)
+		}
+	case []*dto.HashVal :
+		for _, hval := range typedVal {
+			strs = append(strs, &hval.Hkey, &hval.Hval)
+		}
+	case *dto.StreamVal :
+		strs = getStrsOfStreamEntries(typedVal.Entries)
+	}
+	return strs
+}
+func getStrsOfStreamEntries(entries []*dto.StreamEntry) []*string {
+	var strs []*string
+	for _, entry := range entries {
+		for _, field := range entry.Fields {
+			strs = append(strs, &field.Field, &field.Value)
+		}
+	}
+	return strs
+}
\ No newline at end of file
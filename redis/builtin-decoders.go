@@ -0,0 +1,183 @@
+package redis
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"io"
+	"io/ioutil"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/bencase/revis-service/dto"
+)
+
+// Decompressed values larger than this are rejected, so that a small value
+// can't expand into a huge one
+const maxDecompressedSize = 16 * 1024 * 1024
+// Short values are too likely to be base64 by chance
+const minBase64Length = 8
+// The most strings taken from a serialized Java object
+const maxJavaStrings = 100
+
+var InvalidJsonError = errors.New("The value isn't valid JSON")
+
+type gzipDecoder struct {}
+
+func (this *gzipDecoder) Name() string {
+	return dto.DecoderGzip
+}
+func (this *gzipDecoder) Detect(raw []byte) bool {
+	// The magic number, then deflate as the compression method
+	return len(raw) >= 18 && raw[0] == 0x1f && raw[1] == 0x8b && raw[2] == 8
+}
+func (this *gzipDecoder) Decode(raw []byte) (interface{}, error) {
+	reader, err := gzip.NewReader(bytes.NewReader(raw))
+	if err != nil { return nil, err }
+	defer reader.Close()
+	decompressed, err := ioutil.ReadAll(io.LimitReader(reader, maxDecompressedSize + 1))
+	if err != nil { return nil, err }
+	if len(decompressed) > maxDecompressedSize {
+		return nil, errors.New("The decompressed value is too large")
+	}
+	return decompressed, nil
+}
+
+type jsonDecoder struct {}
+
+func (this *jsonDecoder) Name() string {
+	return dto.DecoderJson
+}
+// Only objects and arrays are detected, since numbers and quoted strings are
+// just as likely to be plain values
+func (this *jsonDecoder) Detect(raw []byte) bool {
+	trimmed := bytes.TrimSpace(raw)
+	return len(trimmed) > 0 && (trimmed[0] == '{' || trimmed[0] == '[') && json.Valid(trimmed)
+}
+func (this *jsonDecoder) Decode(raw []byte) (interface{}, error) {
+	if !json.Valid(raw) {
+		return nil, InvalidJsonError
+	}
+	decoder := json.NewDecoder(bytes.NewReader(raw))
+	// Keeps large integers, such as IDs, from losing precision as floats
+	decoder.UseNumber()
+	var val interface{}
+	err := decoder.Decode(&val)
+	if err != nil { return nil, err }
+	return val, nil
+}
+
+// Only detects base64 that decodes to something another decoder detects,
+// since plain words are often valid base64.
+type base64Decoder struct {
+	registry *decoderRegistry
+}
+
+func (this *base64Decoder) Name() string {
+	return dto.DecoderBase64
+}
+func (this *base64Decoder) Detect(raw []byte) bool {
+	if len(raw) < minBase64Length || len(raw) % 4 != 0 {
+		return false
+	}
+	decoded, err := base64.StdEncoding.DecodeString(string(raw))
+	if err != nil {
+		return false
+	}
+	for _, decoder := range this.registry.getOrdered() {
+		if decoder.Name() != this.Name() && decoder.Detect(decoded) {
+			return true
+		}
+	}
+	return false
+}
+func (this *base64Decoder) Decode(raw []byte) (interface{}, error) {
+	return base64.StdEncoding.DecodeString(strings.TrimSpace(string(raw)))
+}
+
+// Java's object serialization can't be decoded without the classes, so this
+// gives a summary of the class names and strings found in the stream. The
+// strings include those of the class descriptions, such as field types.
+type javaDecoder struct {}
+
+var javaStreamMagic = []byte{0xac, 0xed, 0x00, 0x05}
+
+// Type codes from the serialization protocol
+const (
+	javaClassDesc = 0x72
+	javaString = 0x74
+)
+
+func (this *javaDecoder) Name() string {
+	return dto.DecoderJava
+}
+func (this *javaDecoder) Detect(raw []byte) bool {
+	return bytes.HasPrefix(raw, javaStreamMagic)
+}
+func (this *javaDecoder) Decode(raw []byte) (interface{}, error) {
+	if !this.Detect(raw) {
+		return nil, errors.New("The value isn't a serialized Java object")
+	}
+	classNames := []string{}
+	strs := []string{}
+	seenClassNames := make(map[string]bool)
+	for i := len(javaStreamMagic); i < len(raw) && len(strs) < maxJavaStrings; {
+		typeCode := raw[i]
+		if typeCode != javaClassDesc && typeCode != javaString {
+			i++
+			continue
+		}
+		str, next, isStr := readJavaUtf(raw, i + 1)
+		switch {
+		case isStr && typeCode == javaClassDesc && isJavaClassName(str) :
+			if !seenClassNames[str] {
+				seenClassNames[str] = true
+				classNames = append(classNames, str)
+			}
+			i = next
+		case isStr && typeCode == javaString && isPrintable(str) :
+			strs = append(strs, str)
+			i = next
+		default :
+			i++
+		}
+	}
+	return map[string]interface{}{"classNames": classNames, "strings": strs}, nil
+}
+
+// Reads a string prefixed with its length in two bytes. Returns the index
+// after it, and false if there isn't such a string there.
+func readJavaUtf(raw []byte, start int) (string, int, bool) {
+	if start + 2 > len(raw) {
+		return "", 0, false
+	}
+	end := start + 2 + (int(raw[start]) << 8 | int(raw[start + 1]))
+	if end > len(raw) || !utf8.Valid(raw[start + 2:end]) {
+		return "", 0, false
+	}
+	return string(raw[start + 2:end]), end, true
+}
+
+func isJavaClassName(str string) bool {
+	if str == "" {
+		return false
+	}
+	for _, r := range str {
+		if !unicode.IsLetter(r) && !unicode.IsDigit(r) && !strings.ContainsRune("._$[;", r) {
+			return false
+		}
+	}
+	return true
+}
+
+func isPrintable(str string) bool {
+	for _, r := range str {
+		if !unicode.IsPrint(r) && !unicode.IsSpace(r) {
+			return false
+		}
+	}
+	return true
+}
\ No newline at end of file
@@ -0,0 +1,121 @@
+package redis
+
+import (
+	"encoding/json"
+	"reflect"
+	"testing"
+)
+
+func TestGzipDecode(t *testing.T) {
+	decoder := &gzipDecoder{}
+	compressed := gzipBytes(t, []byte("hello"))
+	got, err := decoder.Decode(compressed)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(got, []byte("hello")) {
+		t.Errorf("got %q", got)
+	}
+	if _, err := decoder.Decode(compressed[:len(compressed) - 4]); err == nil {
+		t.Error("decoded a truncated value")
+	}
+	if _, err := decoder.Decode(gzipBytes(t, make([]byte, maxDecompressedSize + 1))); err == nil {
+		t.Error("decoded a value larger than the limit")
+	}
+	if !decoder.Detect(compressed) {
+		t.Error("didn't detect a gzipped value")
+	}
+	if decoder.Detect(compressed[:10]) {
+		t.Error("detected a value too short to be gzipped")
+	}
+}
+
+func TestJsonDecode(t *testing.T) {
+	tests := []struct {
+		name string
+		raw string
+		want interface{}
+		wantErr bool
+	}{
+		{"object", `{"a":true}`, map[string]interface{}{"a": true}, false},
+		{"large integer", `[12345678901234567890]`,
+			[]interface{}{jsonNumber("12345678901234567890")}, false},
+		{"string", `"a"`, "a", false},
+		{"invalid", `{"a":`, nil, true},
+		{"trailing data", `{} {}`, nil, true},
+	}
+	decoder := &jsonDecoder{}
+	for _, test := range tests {
+		got, err := decoder.Decode([]byte(test.raw))
+		if (err != nil) != test.wantErr {
+			t.Errorf("%s: error %v", test.name, err)
+			continue
+		}
+		if !reflect.DeepEqual(got, test.want) {
+			t.Errorf("%s: got %#v, want %#v", test.name, got, test.want)
+		}
+	}
+}
+
+func TestJsonDetect(t *testing.T) {
+	tests := []struct {
+		raw string
+		want bool
+	}{
+		{`{"a":1}`, true},
+		{" [1, 2] \n", true},
+		{`"a"`, false},
+		{"42", false},
+		{"true", false},
+		{"[not json]", false},
+		{"", false},
+	}
+	decoder := &jsonDecoder{}
+	for _, test := range tests {
+		if got := decoder.Detect([]byte(test.raw)); got != test.want {
+			t.Errorf("Detect(%q) = %v, want %v", test.raw, got, test.want)
+		}
+	}
+}
+
+func TestJavaDecode(t *testing.T) {
+	tests := []struct {
+		name string
+		raw []byte
+		wantClassNames []string
+		wantStrings []string
+	}{
+		{"empty stream", javaStream(), []string{}, []string{}},
+		{"class and string", javaStream(0x73, 0x72, 0x00, 0x07, 'a', '.', 'b', '.', 'F', 'o', 'o',
+			0x74, 0x00, 0x02, 'h', 'i'), []string{"a.b.Foo"}, []string{"hi"}},
+		{"repeated class", javaStream(0x72, 0x00, 0x01, 'F', 0x72, 0x00, 0x01, 'F'),
+			[]string{"F"}, []string{}},
+		{"oversized string", javaStream(0x74, 0xff, 0xff, 'h', 'i'), []string{}, []string{}},
+		{"truncated length", javaStream(0x74, 0x00), []string{}, []string{}},
+		{"unprintable string", javaStream(0x74, 0x00, 0x01, 0x01), []string{}, []string{}},
+		{"invalid class name", javaStream(0x72, 0x00, 0x02, 'a', ' '), []string{}, []string{}},
+	}
+	decoder := &javaDecoder{}
+	for _, test := range tests {
+		got, err := decoder.Decode(test.raw)
+		if err != nil {
+			t.Errorf("%s: %v", test.name, err)
+			continue
+		}
+		want := map[string]interface{}{"classNames": test.wantClassNames, "strings": test.wantStrings}
+		if !reflect.DeepEqual(got, want) {
+			t.Errorf("%s: got %#v, want %#v", test.name, got, want)
+		}
+	}
+	if _, err := decoder.Decode([]byte{0xac, 0xed}); err == nil {
+		t.Error("decoded a value without the stream header")
+	}
+}
+
+func javaStream(contents ...byte) []byte {
+	return append(append([]byte{}, javaStreamMagic...), contents...)
+}
+
+func jsonNumber(n string) json.Number {
+	return json.Number(n)
+}
\ No newline at end of file
@@ -0,0 +1,323 @@
+package redis
+
+import (
+	"errors"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/mediocregopher/radix.v2/cluster"
+	rpool "github.com/mediocregopher/radix.v2/pool"
+	"github.com/mediocregopher/radix.v2/redis"
+)
+
+// How long the slot mapping is trusted before it is fetched again at the start of a scan
+const clusterRefreshInterval = 30 * time.Second
+
+var NotClusterError = errors.New("The server is not running in cluster mode")
+
+// clusterNodes keeps a pool for each master of a cluster, along with the
+// mapping of hash slots to the masters which serve them.
+type clusterNodes struct {
+	seedAddr string
+	useTls bool
+	poolSize int
+	dialFunc rpool.DialFunc
+
+	mutex *sync.RWMutex
+	slots [cluster.NumSlots]string
+	pools map[string]*rpool.Pool
+	lastRefreshed time.Time
+}
+
+func newClusterNodes(seedAddr string, useTls bool, poolSize int,
+		dialFunc rpool.DialFunc) (*clusterNodes, error) {
+	cn := &clusterNodes{seedAddr: seedAddr,
+		useTls: useTls,
+		poolSize: poolSize,
+		dialFunc: dialFunc,
+		mutex: &sync.RWMutex{},
+		pools: make(map[string]*rpool.Pool)}
+	err := cn.refresh()
+	if err != nil {
+		cn.Empty()
+		return nil, err
+	}
+	return cn, nil
+}
+
+func (this *clusterNodes) masters() ([]connPool, error) {
+	this.mutex.RLock()
+	isStale := time.Since(this.lastRefreshed) >= clusterRefreshInterval
+	this.mutex.RUnlock()
+	if isStale {
+		err := this.refresh()
+		if err != nil { return nil, err }
+	}
+	this.mutex.RLock()
+	defer this.mutex.RUnlock()
+	pools := make([]connPool, 0, len(this.pools))
+	for _, pool := range this.pools {
+		pools = append(pools, pool)
+	}
+	return pools, nil
+}
+
+func (this *clusterNodes) nodeForKey(key string) (connPool, error) {
+	this.mutex.RLock()
+	defer this.mutex.RUnlock()
+	addr := this.slots[cluster.Slot(key)]
+	pool, hasPool := this.pools[addr]
+	if !hasPool {
+		return nil, errors.New("No cluster node serves the slot of key " + key)
+	}
+	return pool, nil
+}
+
+func (this *clusterNodes) slotForKey(key string) int {
+	return int(cluster.Slot(key))
+}
+
+// Fetches the slot mapping from any known node, opening pools for new masters
+// and closing those of nodes that are no longer masters.
+func (this *clusterNodes) refresh() error {
+	this.mutex.Lock()
+	defer this.mutex.Unlock()
+	addrs := []string{this.seedAddr}
+	for addr := range this.pools {
+		if addr != this.seedAddr {
+			addrs = append(addrs, addr)
+		}
+	}
+	var slotRanges []*clusterSlotRange
+	var err error
+	for _, addr := range addrs {
+		slotRanges, err = this.fetchSlotRanges(addr)
+		if err == nil {
+			break
+		}
+	}
+	if err != nil { return err }
+
+	newSlots := [cluster.NumSlots]string{}
+	newPools := make(map[string]*rpool.Pool)
+	for _, slotRange := range slotRanges {
+		if _, hasPool := newPools[slotRange.addr]; !hasPool {
+			pool, hasOldPool := this.pools[slotRange.addr]
+			if !hasOldPool {
+				pool, err = rpool.NewCustom("tcp", slotRange.addr, this.poolSize, this.dialFunc)
+				if err != nil {
+					for addr, newPool := range newPools {
+						if _, isOld := this.pools[addr]; !isOld {
+							newPool.Empty()
+						}
+					}
+					return err
+				}
+			}
+			newPools[slotRange.addr] = pool
+		}
+		for slot := slotRange.start; slot <= slotRange.end && slot < cluster.NumSlots; slot++ {
+			newSlots[slot] = slotRange.addr
+		}
+	}
+	for addr, pool := range this.pools {
+		if _, isStillMaster := newPools[addr]; !isStillMaster {
+			pool.Empty()
+		}
+	}
+	this.slots = newSlots
+	this.pools = newPools
+	this.lastRefreshed = time.Now()
+	return nil
+}
+func (this *clusterNodes) fetchSlotRanges(addr string) ([]*clusterSlotRange, error) {
+	client, err := this.dialFunc("tcp", addr)
+	if err != nil { return nil, err }
+	defer client.Close()
+	host, _, err := net.SplitHostPort(addr)
+	if err != nil { return nil, err }
+	return discoverClusterSlots(client, host, this.useTls)
+}
+
+func (this *clusterNodes) Empty() {
+	this.mutex.Lock()
+	defer this.mutex.Unlock()
+	for _, pool := range this.pools {
+		pool.Empty()
+	}
+	this.pools = make(map[string]*rpool.Pool)
+}
+
+
+type clusterSlotRange struct {
+	start int
+	end int
+	addr string
+}
+
+// Gets the masters' slot ranges with CLUSTER SHARDS, falling back to
+// CLUSTER SLOTS on servers older than Redis 7. The host is the one the
+// client is connected to, used when a node doesn't announce its own.
+func discoverClusterSlots(client *redis.Client, host string,
+		useTls bool) ([]*clusterSlotRange, error) {
+	resp := client.Cmd("CLUSTER", "SHARDS")
+	if resp.Err == nil {
+		return parseClusterShards(resp, host, useTls)
+	}
+	if resp.IsType(redis.IOErr) {
+		return nil, resp.Err
+	}
+	resp = client.Cmd("CLUSTER", "SLOTS")
+	if resp.Err != nil {
+		if strings.Contains(resp.Err.Error(), "cluster support disabled") {
+			return nil, NotClusterError
+		}
+		return nil, resp.Err
+	}
+	return parseClusterSlots(resp, host)
+}
+
+// Each element of the CLUSTER SLOTS reply is the start and end of a range followed
+// by its nodes, the first of which is the master.
+func parseClusterSlots(resp *redis.Resp, host string) ([]*clusterSlotRange, error) {
+	rangeResps, err := resp.Array()
+	if err != nil { return nil, err }
+	slotRanges := make([]*clusterSlotRange, 0, len(rangeResps))
+	for _, rangeResp := range rangeResps {
+		fields, err := rangeResp.Array()
+		if err != nil { return nil, err }
+		if len(fields) < 3 {
+			return nil, errors.New("Unexpected CLUSTER SLOTS reply")
+		}
+		start, err := fields[0].Int()
+		if err != nil { return nil, err }
+		end, err := fields[1].Int()
+		if err != nil { return nil, err }
+		masterFields, err := fields[2].Array()
+		if err != nil { return nil, err }
+		if len(masterFields) < 2 {
+			return nil, errors.New("Unexpected CLUSTER SLOTS reply")
+		}
+		ip, err := masterFields[0].Str()
+		if err != nil { return nil, err }
+		port, err := masterFields[1].Int()
+		if err != nil { return nil, err }
+		slotRanges = append(slotRanges, &clusterSlotRange{start: start,
+			end: end,
+			addr: getClusterNodeAddr(ip, port, host)})
+	}
+	return slotRanges, nil
+}
+
+// CLUSTER SHARDS replies with a map per shard holding its slot ranges as a flat
+// list of start/end pairs and a list of node maps.
+func parseClusterShards(resp *redis.Resp, host string, useTls bool) ([]*clusterSlotRange, error) {
+	shardResps, err := resp.Array()
+	if err != nil { return nil, err }
+	slotRanges := make([]*clusterSlotRange, 0, len(shardResps))
+	for _, shardResp := range shardResps {
+		shard, err := respToMap(shardResp)
+		if err != nil { return nil, err }
+		slotsResp, hasSlots := shard["slots"]
+		nodesResp, hasNodes := shard["nodes"]
+		if !hasSlots || !hasNodes {
+			return nil, errors.New("Unexpected CLUSTER SHARDS reply")
+		}
+		slotBounds, err := slotsResp.Array()
+		if err != nil { return nil, err }
+		// Shards without slots have nothing to scan
+		if len(slotBounds) == 0 {
+			continue
+		}
+		masterAddr, err := getMasterAddrOfShard(nodesResp, host, useTls)
+		if err != nil { return nil, err }
+		for i := 0; i + 1 < len(slotBounds); i = i + 2 {
+			start, err := slotBounds[i].Int()
+			if err != nil { return nil, err }
+			end, err := slotBounds[i + 1].Int()
+			if err != nil { return nil, err }
+			slotRanges = append(slotRanges, &clusterSlotRange{start: start,
+				end: end,
+				addr: masterAddr})
+		}
+	}
+	return slotRanges, nil
+}
+// TLS clusters announce the port to use as tls-port.
+func getMasterAddrOfShard(nodesResp *redis.Resp, host string, useTls bool) (string, error) {
+	nodeResps, err := nodesResp.Array()
+	if err != nil { return "", err }
+	for _, nodeResp := range nodeResps {
+		node, err := respToMap(nodeResp)
+		if err != nil { return "", err }
+		if getStrFromRespMap(node, "role") != "master" || getStrFromRespMap(node, "health") == "fail" {
+			continue
+		}
+		ip := getStrFromRespMap(node, "endpoint")
+		if ip == "" || ip == "?" {
+			ip = getStrFromRespMap(node, "ip")
+		}
+		portName, otherPortName := "port", "tls-port"
+		if useTls {
+			portName, otherPortName = otherPortName, portName
+		}
+		portResp, hasPort := node[portName]
+		if !hasPort {
+			portResp, hasPort = node[otherPortName]
+		}
+		if !hasPort {
+			return "", errors.New("Cluster node has no port in CLUSTER SHARDS reply")
+		}
+		port, err := portResp.Int()
+		if err != nil { return "", err }
+		return getClusterNodeAddr(ip, port, host), nil
+	}
+	return "", errors.New("A cluster shard has slots but no healthy master")
+}
+
+// True if a node replied that a key's slot is served elsewhere, or that it's
+// read-only, as a master demoted by a failover is. Either way the nodes are
+// refreshed to find the one to send the command to.
+func isRedirectError(err error) bool {
+	if err == nil {
+		return false
+	}
+	msg := err.Error()
+	return strings.HasPrefix(msg, "MOVED ") || strings.HasPrefix(msg, "ASK ") ||
+		strings.HasPrefix(msg, "READONLY ")
+}
+
+// Nodes that don't announce an IP are reached on the host that was queried.
+func getClusterNodeAddr(ip string, port int, host string) string {
+	if ip == "" {
+		ip = host
+	}
+	return net.JoinHostPort(ip, strconv.Itoa(port))
+}
+
+// Converts a reply of alternating names and values into a map.
+func respToMap(resp *redis.Resp) (map[string]*redis.Resp, error) {
+	resps, err := resp.Array()
+	if err != nil { return nil, err }
+	mp := make(map[string]*redis.Resp, len(resps) / 2)
+	for i := 0; i + 1 < len(resps); i = i + 2 {
+		name, err := resps[i].Str()
+		if err != nil { return nil, err }
+		mp[name] = resps[i + 1]
+	}
+	return mp, nil
+}
+func getStrFromRespMap(mp map[string]*redis.Resp, name string) string {
+	resp, hasName := mp[name]
+	if !hasName {
+		return ""
+	}
+	str, err := resp.Str()
+	if err != nil {
+		return ""
+	}
+	return str
+}
\ No newline at end of file
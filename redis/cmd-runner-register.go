@@ -1,6 +1,7 @@
 package redis
 
 import (
+	"sync"
 	"time"
 	
 	"github.com/bencase/revis-service/connections"
@@ -8,7 +9,11 @@ import (
 
 const poolDuration = 31 * time.Minute
 
+// Holds a CmdRunner for each connection in use. It's shared by the HTTP
+// handlers and the timers that close idle runners, so its maps are only
+// touched with the mutex held.
 type CmdRunnerRegister struct {
+	mutex sync.Mutex
 	cmdRunnerMap map[string]RedisCmdRunner
 	timersMap map[string]*time.Timer
 }
@@ -22,6 +27,8 @@ func NewRegister() *CmdRunnerRegister {
 
 func (crr *CmdRunnerRegister) GetCmdRunner(name string) (RedisCmdRunner,
 		error) {
+	crr.mutex.Lock()
+	defer crr.mutex.Unlock()
 	if _, hasCmdRunner := crr.cmdRunnerMap[name]; hasCmdRunner {
 		return crr.getExistingCmdRunner(name)
 	} else {
@@ -29,13 +36,14 @@ func (crr *CmdRunnerRegister) GetCmdRunner(name string) (RedisCmdRunner,
 	}
 }
 
+// The mutex must be held.
 func (crr *CmdRunnerRegister) createCmdRunner(name string) (RedisCmdRunner,
 		error) {
 
 	conn, err := connections.GetConnectionWithName(name);
 	if err != nil { return nil, err }
 	
-	cmdRunner, err := getCmdRunner(conn.Host, conn.Port, conn.Password, conn.Db)
+	cmdRunner, err := getCmdRunner(conn)
 	if err != nil { return nil, err }
 	crr.cmdRunnerMap[name] = cmdRunner
 
@@ -50,7 +58,7 @@ func (crr *CmdRunnerRegister) createCmdRunner(name string) (RedisCmdRunner,
 }
 
 // This method assumes a check has already been done to confirm that
-// the register contains a CmdRunner with this name.
+// the register contains a CmdRunner with this name, and that the mutex is held.
 func (crr *CmdRunnerRegister) getExistingCmdRunner(name string) (RedisCmdRunner, error) {
 
 	timer := crr.timersMap[name]
@@ -60,6 +68,42 @@ func (crr *CmdRunnerRegister) getExistingCmdRunner(name string) (RedisCmdRunner,
 }
 
 func (crr *CmdRunnerRegister) CloseCmdRunner(name string) error {
+	crr.mutex.Lock()
+	cmdRunner := crr.removeCmdRunner(name)
+	crr.mutex.Unlock()
+	if cmdRunner == nil {
+		return nil
+	}
+	return cmdRunner.Close()
+}
+
+// Closes every CmdRunner, e.g. so that no pools with credentials remain open
+// after the connection store is locked.
+func (crr *CmdRunnerRegister) CloseAll() {
+	for _, cmdRunner := range crr.removeAll() {
+		cmdRunner.Close()
+	}
+}
+
+func (crr *CmdRunnerRegister) Close() error {
+	crr.CloseAll()
+	return nil
+}
+
+// Takes the runners out of the register, so that they can be closed without
+// holding the mutex.
+func (crr *CmdRunnerRegister) removeAll() []RedisCmdRunner {
+	crr.mutex.Lock()
+	defer crr.mutex.Unlock()
+	cmdRunners := make([]RedisCmdRunner, 0, len(crr.cmdRunnerMap))
+	for name := range crr.cmdRunnerMap {
+		cmdRunners = append(cmdRunners, crr.removeCmdRunner(name))
+	}
+	return cmdRunners
+}
+
+// Returns nil if there's no CmdRunner with this name. The mutex must be held.
+func (crr *CmdRunnerRegister) removeCmdRunner(name string) RedisCmdRunner {
 	cmdRunner, hasKey := crr.cmdRunnerMap[name]
 	if !hasKey {
 		return nil
@@ -72,12 +116,5 @@ func (crr *CmdRunnerRegister) CloseCmdRunner(name string) error {
 		delete(crr.timersMap, name)
 	}
 
-	return cmdRunner.Close()
-}
-
-func (crr *CmdRunnerRegister) Close() error {
-	for _, cmdRunner := range crr.cmdRunnerMap {
-		cmdRunner.Close()
-	}
-	return nil
+	return cmdRunner
 }
\ No newline at end of file
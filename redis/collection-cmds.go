@@ -0,0 +1,97 @@
+package redis
+
+import (
+	"errors"
+	"strconv"
+
+	"github.com/bencase/revis-service/dto"
+)
+
+var KeyNotFoundError = errors.New("The key doesn't exist")
+var NotCollectionError = errors.New("Only lists, sets, sorted sets and hashes can be paged through")
+var ListMatchError = errors.New("List members can't be matched against a pattern")
+var InvalidCursorError = errors.New("The cursor is invalid")
+
+// Gets a page of the collection's members. Lists are read by index, with the
+// cursor being the index to start from, and the other types are scanned, so
+// the count is only a hint of the page size. Sets, sorted sets and hashes can
+// be limited to the members matching a pattern.
+func (this *iRedisCmdRunner) GetCollectionPage(key string, cursor string, count int,
+		match string) (*dto.CollectionPage, error) {
+	resp, err := this.keyCmd(key, "TYPE", key)
+	if err != nil { return nil, err }
+	typ, err := resp.Str()
+	if err != nil { return nil, err }
+	if typ == typeNone {
+		return nil, KeyNotFoundError
+	}
+	if typ == typeList && match != "" {
+		return nil, ListMatchError
+	}
+	if cursor == "" {
+		cursor = "0"
+	}
+	page := &dto.CollectionPage{Type: typ}
+	switch typ {
+	case typeList : err = this.getListPage(key, cursor, count, page)
+	case typeSet : err = this.getScanPage(key, "SSCAN", "SCARD", cursor, count, match, page)
+	case typeZset : err = this.getScanPage(key, "ZSCAN", "ZCARD", cursor, count, match, page)
+	case typeHash : err = this.getScanPage(key, "HSCAN", "HLEN", cursor, count, match, page)
+	default : return nil, NotCollectionError
+	}
+	if err != nil { return nil, err }
+	return page, nil
+}
+
+func (this *iRedisCmdRunner) getListPage(key string, cursor string, count int,
+		page *dto.CollectionPage) error {
+	start, err := strconv.ParseInt(cursor, 10, 64)
+	if err != nil || start < 0 {
+		return InvalidCursorError
+	}
+	resp, err := this.keyCmd(key, "LLEN", key)
+	if err != nil { return err }
+	page.Count, err = resp.Int64()
+	if err != nil { return err }
+	resp, err = this.keyCmd(key, "LRANGE", key, start, start + int64(count) - 1)
+	if err != nil { return err }
+	members, err := resp.List()
+	if err != nil { return err }
+	page.Members = members
+	page.Encoding = encodeIfBinary(getStrsOfVal(members)...)
+	page.Cursor = "0"
+	if end := start + int64(len(members)); end < page.Count && len(members) > 0 {
+		page.Cursor = strconv.FormatInt(end, 10)
+	}
+	return nil
+}
+
+func (this *iRedisCmdRunner) getScanPage(key string, scanCmd string, countCmd string,
+		cursor string, count int, match string, page *dto.CollectionPage) error {
+	// Cursors can be as large as an unsigned 64-bit number
+	_, err := strconv.ParseUint(cursor, 10, 64)
+	if err != nil {
+		return InvalidCursorError
+	}
+	resp, err := this.keyCmd(key, countCmd, key)
+	if err != nil { return err }
+	page.Count, err = resp.Int64()
+	if err != nil { return err }
+	args := []interface{}{key, cursor}
+	if match != "" {
+		args = append(args, "MATCH", match)
+	}
+	args = append(args, "COUNT", count)
+	resp, err = this.keyCmd(key, scanCmd, args...)
+	if err != nil { return err }
+	nextCursor, vals, err := getScanReply(resp)
+	if err != nil { return err }
+	page.Cursor = nextCursor
+	switch scanCmd {
+	case "SSCAN" : page.Members = vals
+	case "ZSCAN" : page.Members, err = getZsetVals(vals)
+	case "HSCAN" : page.Members = getHashVals(vals)
+	}
+	page.Encoding = encodeIfBinary(getStrsOfVal(page.Members)...)
+	return err
+}
\ No newline at end of file
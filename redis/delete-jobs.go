@@ -0,0 +1,214 @@
+package redis
+
+import (
+	"errors"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/bencase/revis-service/dto"
+)
+
+// How long a finished job can still be polled
+const finishedJobRetention = time.Hour
+
+var DeleteJobNotFoundError = errors.New("Could not find a delete job with that ID")
+
+type deleteJob struct {
+	id int
+	connName string
+	pattern string
+	// Nil if every key matching the pattern is deleted
+	filter *dto.KeyFilter
+	expectedCount int
+	// Set for jobs that back up the keys before deleting them
+	backup *backupWriter
+	startedAt time.Time
+	status *deleteStatus
+	// The fields below are guarded by the register's mutex
+	state string
+	deletedAllKeys bool
+	finishedAt time.Time
+}
+
+type deleteJobRegister struct {
+	mutex sync.Mutex
+	jobs map[int]*deleteJob
+	// It starts at 1 instead of 0 since a 0 may omit the value from the json
+	nextId int
+}
+
+func newDeleteJobRegister() *deleteJobRegister {
+	return &deleteJobRegister{jobs: make(map[int]*deleteJob), nextId: 1}
+}
+
+// Runs the delete in the background with its own CmdRunner, so that it isn't
+// closed by the register while the job is still going.
+func (this *deleteJobRegister) start(conn *dto.Connection, connName string, pattern string,
+		filter *dto.KeyFilter, expectedCount int, backupFirst bool) (*dto.DeleteJob, error) {
+	cmdRunner, err := getCmdRunner(conn)
+	if err != nil { return nil, err }
+	var backup *backupWriter
+	if backupFirst {
+		backup, err = newBackupWriter(connName, pattern)
+		if err != nil {
+			cmdRunner.Close()
+			return nil, err
+		}
+	}
+
+	this.mutex.Lock()
+	this.removeOldJobs()
+	job := &deleteJob{id: this.nextId,
+		connName: connName,
+		pattern: pattern,
+		filter: filter,
+		expectedCount: expectedCount,
+		backup: backup,
+		startedAt: time.Now(),
+		status: NewDeleteStatus(),
+		state: dto.JobRunning}
+	this.nextId++
+	this.jobs[job.id] = job
+	jobDto := this.toDto(job)
+	this.mutex.Unlock()
+
+	go this.run(job, cmdRunner)
+	return jobDto, nil
+}
+
+func (this *deleteJobRegister) run(job *deleteJob, cmdRunner RedisCmdRunner) {
+	defer cmdRunner.Close()
+	var err error
+	// If the pattern is blank or just a star, execute Flush instead of DeleteKeysMatchingPattern
+	// Backing up all keys deletes them one by one rather than flushing, so
+	// that keys written after the backup aren't lost. Filtered deletes never flush.
+	deletedAllKeys := false
+	if job.backup != nil {
+		err = cmdRunner.BackUpAndDeleteKeysMatchingPattern(job.pattern, job.filter, job.status,
+			job.backup)
+		closeErr := job.backup.close(err == nil && !job.status.isCancelled())
+		if closeErr != nil {
+			logger.Error("Error closing backup", job.backup.meta.Id + ":", closeErr)
+		}
+	} else if (job.pattern == "" || job.pattern == "*") && job.filter == nil {
+		err = cmdRunner.Flush()
+		if err == nil {
+			deletedAllKeys = true
+		} else {
+			job.status.setError(err)
+		}
+	} else {
+		err = cmdRunner.DeleteKeysMatchingPattern(job.pattern, job.filter, job.status)
+	}
+
+	this.mutex.Lock()
+	defer this.mutex.Unlock()
+	job.deletedAllKeys = deletedAllKeys
+	job.finishedAt = time.Now()
+	switch {
+	case job.status.isCancelled() : job.state = dto.JobCancelled
+	case err != nil : job.state = dto.JobFailed
+	default : job.state = dto.JobCompleted
+	}
+	if err != nil {
+		logger.Error("Delete job", job.id, "for pattern", job.pattern, "failed:", err)
+	}
+}
+
+func (this *deleteJobRegister) get(id int) (*dto.DeleteJob, error) {
+	this.mutex.Lock()
+	defer this.mutex.Unlock()
+	job, hasJob := this.jobs[id]
+	if !hasJob {
+		return nil, DeleteJobNotFoundError
+	}
+	return this.toDto(job), nil
+}
+
+// Returns every job, newest first.
+func (this *deleteJobRegister) getAll() []*dto.DeleteJob {
+	this.mutex.Lock()
+	defer this.mutex.Unlock()
+	this.removeOldJobs()
+	jobDtos := make([]*dto.DeleteJob, 0, len(this.jobs))
+	for _, job := range this.jobs {
+		jobDtos = append(jobDtos, this.toDto(job))
+	}
+	sort.Slice(jobDtos, func(i, j int) bool { return jobDtos[i].Id > jobDtos[j].Id })
+	return jobDtos
+}
+
+// Stops the job after the keys currently being unlinked. Cancelling a job
+// that has finished does nothing.
+func (this *deleteJobRegister) cancel(id int) (*dto.DeleteJob, error) {
+	this.mutex.Lock()
+	defer this.mutex.Unlock()
+	job, hasJob := this.jobs[id]
+	if !hasJob {
+		return nil, DeleteJobNotFoundError
+	}
+	job.status.cancel()
+	return this.toDto(job), nil
+}
+
+func (this *deleteJobRegister) cancelAll() {
+	this.mutex.Lock()
+	defer this.mutex.Unlock()
+	for _, job := range this.jobs {
+		job.status.cancel()
+	}
+}
+
+// Must be called with the mutex held.
+func (this *deleteJobRegister) removeOldJobs() {
+	cutoff := time.Now().Add(-finishedJobRetention)
+	for id, job := range this.jobs {
+		if job.state != dto.JobRunning && job.finishedAt.Before(cutoff) {
+			delete(this.jobs, id)
+		}
+	}
+}
+
+// Must be called with the mutex held.
+func (this *deleteJobRegister) toDto(job *deleteJob) *dto.DeleteJob {
+	jobDto := &dto.DeleteJob{Id: job.id,
+		ConnName: job.connName,
+		Pattern: job.pattern,
+		Filter: job.filter,
+		State: job.state,
+		Scanned: job.status.getScanned(),
+		Deleted: job.status.getCount(),
+		DeletedAllKeys: job.deletedAllKeys,
+		ExpectedCount: job.expectedCount,
+		Errors: job.status.getErrorCount(),
+		BackedUp: job.status.getBackedUp(),
+		StartedAt: job.startedAt.UTC().Format(time.RFC3339)}
+	jobDto.PatternEncoding = encodeIfBinary(&jobDto.Pattern)
+	if job.filter != nil {
+		jobDto.FilterCounts = job.status.getFilterCounts()
+	}
+	if job.backup != nil {
+		jobDto.BackupId = job.backup.meta.Id
+	}
+	if err := job.status.getError(); err != nil {
+		jobDto.LastError = err.Error()
+	}
+	endTime := time.Now()
+	if job.state != dto.JobRunning {
+		endTime = job.finishedAt
+		jobDto.FinishedAt = job.finishedAt.UTC().Format(time.RFC3339)
+	}
+	elapsed := endTime.Sub(job.startedAt).Seconds()
+	if elapsed > 0 {
+		jobDto.Rate = float64(jobDto.Deleted) / elapsed
+	}
+	if job.state == dto.JobRunning && job.expectedCount > 0 && jobDto.Rate > 0 {
+		remaining := job.expectedCount - jobDto.Deleted
+		if remaining < 0 {
+			remaining = 0
+		}
+		jobDto.EtaSeconds = float64(remaining) / jobDto.Rate
+	}
+	return jobDto
+}
\ No newline at end of file
@@ -0,0 +1,198 @@
+package redis
+
+import (
+	"strings"
+
+	"github.com/mediocregopher/radix.v2/redis"
+
+	"github.com/bencase/revis-service/dto"
+	ki "github.com/bencase/revis-service/redis/keyiterator"
+)
+
+type InvalidKeyFilterError struct {
+	Message string
+}
+func (this *InvalidKeyFilterError) Error() string {
+	return this.Message
+}
+
+// Returns nil for a filter that doesn't filter anything, so callers only need
+// to check for nil.
+func normalizeKeyFilter(filter *dto.KeyFilter) (*dto.KeyFilter, error) {
+	if filter == nil || *filter == (dto.KeyFilter{}) {
+		return nil, nil
+	}
+	switch filter.Type {
+	case "", typeString, typeList, typeSet, typeZset, typeHash, typeStream :
+	default :
+		return nil, &InvalidKeyFilterError{"Unknown key type \"" + filter.Type + "\" in the key filter"}
+	}
+	switch filter.Ttl {
+	case "", dto.TtlWith, dto.TtlWithout :
+	default :
+		return nil, &InvalidKeyFilterError{"The TTL filter must be " + dto.TtlWith + " or " + dto.TtlWithout}
+	}
+	if filter.MinTtl < 0 || filter.MaxTtl < 0 || filter.MinIdle < 0 {
+		return nil, &InvalidKeyFilterError{"TTLs and idle times in the key filter can't be negative"}
+	}
+	if filter.MaxTtl != 0 && filter.MinTtl > filter.MaxTtl {
+		return nil, &InvalidKeyFilterError{"The minimum TTL in the key filter is above the maximum"}
+	}
+	if (filter.MinTtl != 0 || filter.MaxTtl != 0) && filter.Ttl == dto.TtlWithout {
+		return nil, &InvalidKeyFilterError{"A TTL range can't be given for keys without a TTL"}
+	}
+	return filter, nil
+}
+
+func keyFiltersEqual(filter1 *dto.KeyFilter, filter2 *dto.KeyFilter) bool {
+	if filter1 == nil || filter2 == nil {
+		return filter1 == filter2
+	}
+	return *filter1 == *filter2
+}
+
+// Iterates over the keys matching the pattern, asking the server to filter by
+// type where it can. Returns true if the type has been left to the server.
+func (this *iRedisCmdRunner) newFilteredKeyIterator(pattern string,
+		filter *dto.KeyFilter) (ki.KeyIterator, bool, error) {
+	if filter == nil || filter.Type == "" {
+		keyIterator, err := this.newKeyIterator(pattern)
+		return keyIterator, false, err
+	}
+	keyIterator, err := this.newKeyIteratorOfType(pattern, filter.Type)
+	if err != nil && strings.Contains(err.Error(), "syntax error") {
+		// Servers before Redis 6 don't support SCAN TYPE, so the type is checked per key
+		keyIterator, err = this.newKeyIterator(pattern)
+		return keyIterator, false, err
+	}
+	return keyIterator, true, err
+}
+
+// Returns the keys that pass the filter, along with the number each part of
+// the filter excluded. The type is only checked here if the server didn't
+// already filter by it. Keys that no longer exist are dropped without being counted.
+func (this *iRedisCmdRunner) filterKeys(keys []string, filter *dto.KeyFilter,
+		typeFilteredByServer bool) ([]string, *dto.FilterCounts, error) {
+	counts := &dto.FilterCounts{TypeFilteredByServer: typeFilteredByServer}
+	if filter == nil || len(keys) == 0 {
+		return keys, counts, nil
+	}
+	filteredKeys, err := this.filterKeysOnNodes(keys, filter, counts)
+	// If a cluster's slots have moved, refresh the mapping and try once more
+	if isRedirectError(err) {
+		err = this.nodes.refresh()
+		if err != nil { return nil, nil, err }
+		counts = &dto.FilterCounts{TypeFilteredByServer: typeFilteredByServer}
+		filteredKeys, err = this.filterKeysOnNodes(keys, filter, counts)
+	}
+	if err != nil { return nil, nil, err }
+	return filteredKeys, counts, nil
+}
+func (this *iRedisCmdRunner) filterKeysOnNodes(keys []string, filter *dto.KeyFilter,
+		counts *dto.FilterCounts) ([]string, error) {
+	keysByNode := make(map[connPool][]string)
+	for _, key := range keys {
+		pool, err := this.nodes.nodeForKey(key)
+		if err != nil { return nil, err }
+		keysByNode[pool] = append(keysByNode[pool], key)
+	}
+	filteredKeys := make([]string, 0, len(keys))
+	for pool, nodeKeys := range keysByNode {
+		nodeFilteredKeys, err := filterKeysOnNode(pool, nodeKeys, filter, counts)
+		if err != nil { return nil, err }
+		filteredKeys = append(filteredKeys, nodeFilteredKeys...)
+	}
+	return filteredKeys, nil
+}
+func filterKeysOnNode(pool connPool, keys []string, filter *dto.KeyFilter,
+		counts *dto.FilterCounts) ([]string, error) {
+	checkType := filter.Type != "" && !counts.TypeFilteredByServer
+	checkTtl := filter.Ttl != "" || filter.MinTtl != 0 || filter.MaxTtl != 0
+	checkIdle := filter.MinIdle != 0
+	if !checkType && !checkTtl && !checkIdle {
+		return keys, nil
+	}
+	conn, err := pool.Get()
+	if err != nil { return nil, err }
+	defer pool.Put(conn)
+	// The idle time is read first, though neither TYPE nor PTTL reset it
+	for _, key := range keys {
+		if checkIdle {
+			conn.PipeAppend("OBJECT", "IDLETIME", key)
+		}
+		if checkType {
+			conn.PipeAppend("TYPE", key)
+		}
+		if checkTtl {
+			conn.PipeAppend("PTTL", key)
+		}
+	}
+	// OBJECT IDLETIME fails for servers with an LFU eviction policy, which
+	// fails the whole filter rather than deleting keys that may be in use
+	resps, err := getResponsesFromPipeline(conn)
+	if err != nil { return nil, err }
+
+	filteredKeys := make([]string, 0, len(keys))
+	i := 0
+	for _, key := range keys {
+		exists := true
+		var idle, pttl int64
+		var typ string
+		if checkIdle {
+			if resps[i].IsType(redis.Nil) {
+				exists = false
+			} else {
+				idle, err = resps[i].Int64()
+				if err != nil { return nil, err }
+			}
+			i++
+		}
+		if checkType {
+			typ, err = resps[i].Str()
+			if err != nil { return nil, err }
+			exists = exists && typ != typeNone
+			i++
+		}
+		if checkTtl {
+			pttl, err = resps[i].Int64()
+			if err != nil { return nil, err }
+			// PTTL is -2 for a key that doesn't exist
+			exists = exists && pttl != -2
+			i++
+		}
+		switch {
+		case !exists :
+		case checkIdle && idle < filter.MinIdle : counts.Idle++
+		case checkType && typ != filter.Type : counts.Type++
+		case checkTtl && !ttlPassesFilter(pttl, filter) : counts.Ttl++
+		default : filteredKeys = append(filteredKeys, key)
+		}
+	}
+	return filteredKeys, nil
+}
+
+// PTTL is -1 for a key without an expiry.
+func ttlPassesFilter(pttl int64, filter *dto.KeyFilter) bool {
+	hasTtl := pttl >= 0
+	if filter.Ttl == dto.TtlWithout {
+		return !hasTtl
+	}
+	if !hasTtl {
+		return false
+	}
+	ttlSeconds := pttl / 1000
+	if filter.MinTtl != 0 && ttlSeconds < filter.MinTtl {
+		return false
+	}
+	if filter.MaxTtl != 0 && ttlSeconds > filter.MaxTtl {
+		return false
+	}
+	return true
+}
+
+func addFilterCounts(total *dto.FilterCounts, counts *dto.FilterCounts) {
+	total.Type += counts.Type
+	total.Ttl += counts.Ttl
+	total.Idle += counts.Idle
+	total.TypeFilteredByServer = counts.TypeFilteredByServer
+}
\ No newline at end of file
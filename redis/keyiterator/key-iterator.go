@@ -4,7 +4,6 @@ import (
 	"errors"
 	"io"
 
-	rpool "github.com/mediocregopher/radix.v2/pool"
 	"github.com/mediocregopher/radix.v2/redis"
 
 	"github.com/bencase/revis-service/dto"
@@ -18,6 +17,13 @@ const minimumSizeOfList = 200
 
 var NoMoreElements = errors.New("There are no further elements in this iterator")
 
+// Pool is the source of connections for the iterator. It is satisfied by
+// radix's *pool.Pool as well as the sentinel-aware pools.
+type Pool interface {
+	Get() (*redis.Client, error)
+	Put(conn *redis.Client)
+}
+
 type KeyIterator interface {
 	HasNext() bool
 	Next() (*dto.Key, error)
@@ -25,23 +31,45 @@ type KeyIterator interface {
 }
 
 type iKeyIterator struct {
-	pool *rpool.Pool
+	pools []Pool
+	poolIndex int
 	conn *redis.Client
 	pattern string
+	// Passed to SCAN as TYPE when not blank
+	keyType string
 	scanCursor int
 	keysList []*dto.Key
 	index int
 	err error
 }
 
-func NewKeyIterator(pool *rpool.Pool, pattern string) (KeyIterator, error) {
-	conn, err := pool.Get()
-	if err != nil { return nil, err }
-	initialCursorVal, keysList, err := getKeysList(conn, 0, pattern)
+func NewKeyIterator(pool Pool, pattern string) (KeyIterator, error) {
+	return NewMultiNodeKeyIterator([]Pool{pool}, pattern)
+}
+
+// Scans each of the pools in turn, as is needed to see every key in a cluster.
+func NewMultiNodeKeyIterator(pools []Pool, pattern string) (KeyIterator, error) {
+	return NewMultiNodeKeyIteratorOfType(pools, pattern, "")
+}
+
+// Only returns keys of the type, which is left to the server. SCAN only takes
+// a type from Redis 6 on, and older servers fail with a syntax error.
+func NewMultiNodeKeyIteratorOfType(pools []Pool, pattern string, keyType string) (KeyIterator, error) {
+	if len(pools) == 0 {
+		return nil, errors.New("There are no nodes to scan")
+	}
+	conn, err := pools[0].Get()
 	if err != nil { return nil, err }
-	keyIterator := &iKeyIterator{pool: pool,
+	initialCursorVal, keysList, err := getKeysList(conn, 0, pattern, keyType)
+	if err != nil {
+		pools[0].Put(conn)
+		return nil, err
+	}
+	keyIterator := &iKeyIterator{pools: pools,
+		poolIndex: 0,
 		conn: conn,
 		pattern: pattern,
+		keyType: keyType,
 		scanCursor: initialCursorVal,
 		keysList: keysList,
 		index: 0}
@@ -49,7 +77,12 @@ func NewKeyIterator(pool *rpool.Pool, pattern string) (KeyIterator, error) {
 }
 
 func (this *iKeyIterator) HasNext() bool {
-	return (this.index < len(this.keysList) || this.scanCursor != 0) && this.err == nil
+	return (this.index < len(this.keysList) || this.hasMoreToScan()) && this.err == nil
+}
+
+// True if the current node's scan isn't finished or there are nodes yet to scan
+func (this *iKeyIterator) hasMoreToScan() bool {
+	return this.scanCursor != 0 || this.poolIndex < len(this.pools) - 1
 }
 
 func (this *iKeyIterator) Next() (*dto.Key, error) {
@@ -60,7 +93,7 @@ func (this *iKeyIterator) Next() (*dto.Key, error) {
 
 	var key *dto.Key
 	if this.index >= len(this.keysList) {
-		if this.scanCursor == 0 {
+		if !this.hasMoreToScan() {
 			return nil, NoMoreElements
 		} else {
 			err := this.refillKeyStrList()
@@ -86,27 +119,46 @@ func (this *iKeyIterator) refillKeyStrList() error {
 	continueScanning:= true
 	var keysScanned []*dto.Key
 	for continueScanning {
-		newCursorVal, newKeys, err := getKeysList(this.conn, this.scanCursor, this.pattern)
+		// A zero cursor here means the current node is finished, so move on to the next
+		if this.scanCursor == 0 {
+			err := this.moveToNextPool()
+			if err != nil { return err }
+		}
+		newCursorVal, newKeys, err := getKeysList(this.conn, this.scanCursor, this.pattern, this.keyType)
 		if err != nil { return err }
 		this.scanCursor = newCursorVal
 		keysScanned = append(keysScanned, newKeys...)
-		continueScanning = !(len(keysScanned) >= minimumSizeOfList || newCursorVal == 0)
+		continueScanning = !(len(keysScanned) >= minimumSizeOfList || !this.hasMoreToScan())
 	}
 	this.keysList = keysScanned
 	return nil
 }
 
-func getKeysList(conn *redis.Client, scanCursor int, pattern string) (int, []*dto.Key, error) {
+func (this *iKeyIterator) moveToNextPool() error {
+	this.pools[this.poolIndex].Put(this.conn)
+	this.conn = nil
+	this.poolIndex++
+	conn, err := this.pools[this.poolIndex].Get()
+	if err != nil { return err }
+	this.conn = conn
+	return nil
+}
+
+func getKeysList(conn *redis.Client, scanCursor int, pattern string,
+		keyType string) (int, []*dto.Key, error) {
 
 	var cursorVal int
 	var keys []*dto.Key
 
-	resp := conn.Cmd("scan",
-		scanCursor,
+	args := []interface{}{scanCursor,
 		"match",
 		pattern,
 		"count",
-		defaultScanSize)
+		defaultScanSize}
+	if keyType != "" {
+		args = append(args, "type", keyType)
+	}
+	resp := conn.Cmd("scan", args...)
 	respSlice, err := resp.Array()
 	if resp.Err != nil { return 0, keys, resp.Err }
 
@@ -144,6 +196,9 @@ func getKeysFromArrayResp(outerResp *redis.Resp) ([]string, error) {
 }
 
 func (this *iKeyIterator) Close() error {
-	this.pool.Put(this.conn)
+	if this.conn != nil {
+		this.pools[this.poolIndex].Put(this.conn)
+		this.conn = nil
+	}
 	return nil
 }
\ No newline at end of file
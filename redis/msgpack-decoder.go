@@ -0,0 +1,204 @@
+package redis
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math"
+
+	"github.com/bencase/revis-service/dto"
+)
+
+// Deeper nesting is rejected rather than risking the stack
+const maxDecodeDepth = 100
+
+var TruncatedValueError = errors.New("The value ends too soon")
+
+// Only maps and arrays are detected, since almost any byte starts some other
+// MessagePack value
+type msgpackDecoder struct {}
+
+func (this *msgpackDecoder) Name() string {
+	return dto.DecoderMsgpack
+}
+func (this *msgpackDecoder) Detect(raw []byte) bool {
+	if len(raw) < 2 {
+		return false
+	}
+	first := raw[0]
+	isContainer := (first >= 0x80 && first <= 0x9f) || (first >= 0xdc && first <= 0xdf)
+	if !isContainer {
+		return false
+	}
+	_, err := this.Decode(raw)
+	return err == nil
+}
+func (this *msgpackDecoder) Decode(raw []byte) (interface{}, error) {
+	reader := &msgpackReader{raw: raw}
+	val, err := reader.read(0)
+	if err != nil { return nil, err }
+	if reader.pos != len(raw) {
+		return nil, errors.New("There's more data after the value")
+	}
+	return val, nil
+}
+
+type msgpackReader struct {
+	raw []byte
+	pos int
+}
+
+func (this *msgpackReader) read(depth int) (interface{}, error) {
+	if depth > maxDecodeDepth {
+		return nil, errors.New("The value is nested too deeply")
+	}
+	b, err := this.next(1)
+	if err != nil { return nil, err }
+	typeByte := b[0]
+	switch {
+	case typeByte <= 0x7f : return int64(typeByte), nil
+	case typeByte >= 0xe0 : return int64(int8(typeByte)), nil
+	case typeByte <= 0x8f : return this.readMap(int(typeByte & 0x0f), depth)
+	case typeByte <= 0x9f : return this.readArray(int(typeByte & 0x0f), depth)
+	case typeByte <= 0xbf : return this.readStr(int(typeByte & 0x1f))
+	}
+	switch typeByte {
+	case 0xc0 : return nil, nil
+	case 0xc2 : return false, nil
+	case 0xc3 : return true, nil
+	case 0xc4, 0xc5, 0xc6 :
+		length, err := this.readLength(typeByte - 0xc4)
+		if err != nil { return nil, err }
+		// Marshalled to json as base64
+		return this.next(length)
+	case 0xc7, 0xc8, 0xc9 :
+		length, err := this.readLength(typeByte - 0xc7)
+		if err != nil { return nil, err }
+		return this.readExt(length)
+	case 0xca :
+		b, err := this.next(4)
+		if err != nil { return nil, err }
+		return toJsonFloat(float64(math.Float32frombits(binary.BigEndian.Uint32(b)))), nil
+	case 0xcb :
+		b, err := this.next(8)
+		if err != nil { return nil, err }
+		return toJsonFloat(math.Float64frombits(binary.BigEndian.Uint64(b))), nil
+	case 0xcc, 0xcd, 0xce, 0xcf :
+		b, err := this.next(1 << (typeByte - 0xcc))
+		if err != nil { return nil, err }
+		return readUint(b), nil
+	case 0xd0, 0xd1, 0xd2, 0xd3 :
+		b, err := this.next(1 << (typeByte - 0xd0))
+		if err != nil { return nil, err }
+		return readInt(b), nil
+	case 0xd4, 0xd5, 0xd6, 0xd7, 0xd8 :
+		return this.readExt(1 << (typeByte - 0xd4))
+	case 0xd9, 0xda, 0xdb :
+		length, err := this.readLength(typeByte - 0xd9)
+		if err != nil { return nil, err }
+		return this.readStr(length)
+	case 0xdc, 0xdd :
+		length, err := this.readLength(typeByte - 0xdc + 1)
+		if err != nil { return nil, err }
+		return this.readArray(length, depth)
+	case 0xde, 0xdf :
+		length, err := this.readLength(typeByte - 0xde + 1)
+		if err != nil { return nil, err }
+		return this.readMap(length, depth)
+	}
+	return nil, fmt.Errorf("Unknown type byte 0x%x", typeByte)
+}
+
+func (this *msgpackReader) next(length int) ([]byte, error) {
+	if length < 0 || length > len(this.raw) - this.pos {
+		return nil, TruncatedValueError
+	}
+	b := this.raw[this.pos:this.pos + length]
+	this.pos += length
+	return b, nil
+}
+
+// Reads a length of 1, 2 or 4 bytes, for sizes 0, 1 and 2.
+func (this *msgpackReader) readLength(size byte) (int, error) {
+	b, err := this.next(1 << size)
+	if err != nil { return 0, err }
+	length := readUint(b)
+	// Every element takes at least a byte, which also bounds what's allocated
+	if length > uint64(len(this.raw) - this.pos) {
+		return 0, TruncatedValueError
+	}
+	return int(length), nil
+}
+
+func (this *msgpackReader) readStr(length int) (interface{}, error) {
+	b, err := this.next(length)
+	if err != nil { return nil, err }
+	return string(b), nil
+}
+
+func (this *msgpackReader) readArray(length int, depth int) (interface{}, error) {
+	if length > len(this.raw) - this.pos {
+		return nil, TruncatedValueError
+	}
+	vals := make([]interface{}, length)
+	for i := range vals {
+		val, err := this.read(depth + 1)
+		if err != nil { return nil, err }
+		vals[i] = val
+	}
+	return vals, nil
+}
+
+// Keys that aren't strings are formatted as strings, since json only has those.
+func (this *msgpackReader) readMap(length int, depth int) (interface{}, error) {
+	if length > len(this.raw) - this.pos {
+		return nil, TruncatedValueError
+	}
+	vals := make(map[string]interface{}, length)
+	for i := 0; i < length; i++ {
+		key, err := this.read(depth + 1)
+		if err != nil { return nil, err }
+		val, err := this.read(depth + 1)
+		if err != nil { return nil, err }
+		if keyStr, isStr := key.(string); isStr {
+			vals[keyStr] = val
+		} else {
+			vals[fmt.Sprint(key)] = val
+		}
+	}
+	return vals, nil
+}
+
+// Extension types are application-specific, so they're shown as their type
+// and data
+func (this *msgpackReader) readExt(length int) (interface{}, error) {
+	b, err := this.next(1)
+	if err != nil { return nil, err }
+	data, err := this.next(length)
+	if err != nil { return nil, err }
+	return map[string]interface{}{"extType": int8(b[0]), "data": data}, nil
+}
+
+func readUint(b []byte) uint64 {
+	var n uint64
+	for _, byt := range b {
+		n = n << 8 | uint64(byt)
+	}
+	return n
+}
+
+func readInt(b []byte) int64 {
+	n := int64(int8(b[0]))
+	for _, byt := range b[1:] {
+		n = n << 8 | int64(byt)
+	}
+	return n
+}
+
+// json has no infinities or NaN, so those are shown as strings
+func toJsonFloat(f float64) interface{} {
+	if math.IsInf(f, 0) || math.IsNaN(f) {
+		return fmt.Sprint(f)
+	}
+	return f
+}
\ No newline at end of file
@@ -0,0 +1,102 @@
+package redis
+
+import (
+	"bytes"
+	"math"
+	"reflect"
+	"testing"
+)
+
+func TestMsgpackDecode(t *testing.T) {
+	tests := []struct {
+		name string
+		raw []byte
+		want interface{}
+		wantErr error
+	}{
+		{"positive fixint", []byte{0x05}, int64(5), nil},
+		{"negative fixint", []byte{0xff}, int64(-1), nil},
+		{"nil", []byte{0xc0}, nil, nil},
+		{"bool", []byte{0xc3}, true, nil},
+		{"fixstr", []byte{0xa2, 'h', 'i'}, "hi", nil},
+		{"uint64", []byte{0xcf, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff},
+			uint64(math.MaxUint64), nil},
+		{"int16", []byte{0xd1, 0xff, 0xfe}, int64(-2), nil},
+		{"float64", []byte{0xcb, 0x3f, 0xe0, 0, 0, 0, 0, 0, 0}, 0.5, nil},
+		{"float32 NaN", []byte{0xca, 0x7f, 0xc0, 0, 0}, "NaN", nil},
+		{"bin8", []byte{0xc4, 0x02, 0x01, 0x02}, []byte{0x01, 0x02}, nil},
+		{"fixext1", []byte{0xd4, 0x01, 0x09},
+			map[string]interface{}{"extType": int8(1), "data": []byte{0x09}}, nil},
+		{"fixarray", []byte{0x92, 0x01, 0xc2}, []interface{}{int64(1), false}, nil},
+		{"fixmap", []byte{0x81, 0xa1, 'a', 0x01}, map[string]interface{}{"a": int64(1)}, nil},
+		{"map with an integer key", []byte{0x81, 0x07, 0xc0}, map[string]interface{}{"7": nil}, nil},
+		{"empty", []byte{}, nil, TruncatedValueError},
+		{"truncated fixstr", []byte{0xa5, 'h', 'i'}, nil, TruncatedValueError},
+		{"truncated float", []byte{0xcb, 0x3f, 0xe0}, nil, TruncatedValueError},
+		{"truncated length", []byte{0xda, 0x01}, nil, TruncatedValueError},
+		{"oversized str32", []byte{0xdb, 0x7f, 0xff, 0xff, 0xff, 'a'}, nil, TruncatedValueError},
+		{"oversized bin8", []byte{0xc4, 0x05, 0x01, 0x02}, nil, TruncatedValueError},
+		{"oversized array32", []byte{0xdd, 0xff, 0xff, 0xff, 0xff, 0x01}, nil, TruncatedValueError},
+		{"oversized map32", []byte{0xdf, 0xff, 0xff, 0xff, 0xff, 0x01}, nil, TruncatedValueError},
+		{"oversized fixarray", []byte{0x9f, 0x01}, nil, TruncatedValueError},
+		{"ext without its type", []byte{0xd4}, nil, TruncatedValueError},
+	}
+	decoder := &msgpackDecoder{}
+	for _, test := range tests {
+		got, err := decoder.Decode(test.raw)
+		if err != test.wantErr {
+			t.Errorf("%s: error %v, want %v", test.name, err, test.wantErr)
+			continue
+		}
+		if !reflect.DeepEqual(got, test.want) {
+			t.Errorf("%s: got %#v, want %#v", test.name, got, test.want)
+		}
+	}
+}
+
+func TestMsgpackDecodeInvalid(t *testing.T) {
+	tests := []struct {
+		name string
+		raw []byte
+	}{
+		{"unused type byte", []byte{0xc1}},
+		{"trailing data", []byte{0x01, 0x02}},
+		{"nested too deeply", nestedMsgpackArrays(maxDecodeDepth + 1)},
+	}
+	decoder := &msgpackDecoder{}
+	for _, test := range tests {
+		if _, err := decoder.Decode(test.raw); err == nil {
+			t.Errorf("%s: decoded without an error", test.name)
+		}
+	}
+	if _, err := decoder.Decode(nestedMsgpackArrays(maxDecodeDepth)); err != nil {
+		t.Errorf("nested to the limit: %v", err)
+	}
+}
+
+func TestMsgpackDetect(t *testing.T) {
+	tests := []struct {
+		name string
+		raw []byte
+		want bool
+	}{
+		{"array", []byte{0x92, 0x01, 0x02}, true},
+		{"map", []byte{0x81, 0xa1, 'a', 0x01}, true},
+		{"array16", []byte{0xdc, 0x00, 0x01, 0xc0}, true},
+		{"truncated array", []byte{0x92, 0x01}, false},
+		{"scalar", []byte{0x01, 0x02}, false},
+		{"too short", []byte{0x90}, false},
+		{"text", []byte("hello"), false},
+	}
+	decoder := &msgpackDecoder{}
+	for _, test := range tests {
+		if got := decoder.Detect(test.raw); got != test.want {
+			t.Errorf("%s: detected %v, want %v", test.name, got, test.want)
+		}
+	}
+}
+
+// Returns arrays nested depth times around a nil, so that the nil is at that depth.
+func nestedMsgpackArrays(depth int) []byte {
+	return append(bytes.Repeat([]byte{0x91}, depth), 0xc0)
+}
\ No newline at end of file
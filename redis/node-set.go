@@ -0,0 +1,39 @@
+package redis
+
+// The nodes that hold a connection's keys. Standalone and sentinel connections
+// have a single node, while a cluster spreads its keys across several masters.
+type nodeSet interface {
+	// Returns the pools of every master, all of which must be scanned to see every key
+	masters() ([]connPool, error)
+	// Returns the pool of the master which holds the key
+	nodeForKey(key string) (connPool, error)
+	// Keys with the same slot can be used together in one multi-key command
+	slotForKey(key string) int
+	// Called when a node answers that it no longer holds a key
+	refresh() error
+	Empty()
+}
+
+type singleNode struct {
+	pool connPool
+}
+
+func (this *singleNode) masters() ([]connPool, error) {
+	return []connPool{this.pool}, nil
+}
+func (this *singleNode) nodeForKey(key string) (connPool, error) {
+	return this.pool, nil
+}
+func (this *singleNode) slotForKey(key string) int {
+	return 0
+}
+func (this *singleNode) refresh() error {
+	// A sentinel connection's master may have failed over
+	if sentinel, isSentinel := this.pool.(*sentinelPool); isSentinel {
+		sentinel.refresh()
+	}
+	return nil
+}
+func (this *singleNode) Empty() {
+	this.pool.Empty()
+}
\ No newline at end of file
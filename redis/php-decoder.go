@@ -0,0 +1,239 @@
+package redis
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/bencase/revis-service/dto"
+)
+
+// Decodes values from PHP's serialize. Objects become maps with their class
+// name under phpClassField, and references to earlier values are shown as
+// their index under phpRefField.
+type phpDecoder struct {}
+
+const (
+	phpClassField = "__class"
+	phpRefField = "__ref"
+	// The data of classes that serialize themselves, which only they can read
+	phpDataField = "__data"
+)
+
+func (this *phpDecoder) Name() string {
+	return dto.DecoderPhp
+}
+func (this *phpDecoder) Detect(raw []byte) bool {
+	if len(raw) < 2 {
+		return false
+	}
+	isNull := raw[0] == 'N' && raw[1] == ';'
+	if !isNull && (raw[1] != ':' || !strings.ContainsRune("bidsaOCErR", rune(raw[0]))) {
+		return false
+	}
+	_, err := this.Decode(raw)
+	return err == nil
+}
+func (this *phpDecoder) Decode(raw []byte) (interface{}, error) {
+	reader := &phpReader{raw: raw}
+	val, err := reader.read(0)
+	if err != nil { return nil, err }
+	if reader.pos != len(raw) {
+		return nil, errors.New("There's more data after the value")
+	}
+	return val, nil
+}
+
+type phpReader struct {
+	raw []byte
+	pos int
+}
+
+func (this *phpReader) read(depth int) (interface{}, error) {
+	if depth > maxDecodeDepth {
+		return nil, errors.New("The value is nested too deeply")
+	}
+	typeByte, err := this.readByte()
+	if err != nil { return nil, err }
+	if typeByte == 'N' {
+		return nil, this.expect(';')
+	}
+	err = this.expect(':')
+	if err != nil { return nil, err }
+	switch typeByte {
+	case 'b' :
+		str, err := this.readUntil(';')
+		if err != nil { return nil, err }
+		if str != "0" && str != "1" {
+			return nil, errors.New("Invalid boolean " + str)
+		}
+		return str == "1", nil
+	case 'i', 'r', 'R' :
+		str, err := this.readUntil(';')
+		if err != nil { return nil, err }
+		n, err := strconv.ParseInt(str, 10, 64)
+		if err != nil { return nil, err }
+		if typeByte != 'i' {
+			return map[string]interface{}{phpRefField: n}, nil
+		}
+		return n, nil
+	case 'd' :
+		str, err := this.readUntil(';')
+		if err != nil { return nil, err }
+		switch str {
+		case "INF", "-INF", "NAN" : return str, nil
+		}
+		f, err := strconv.ParseFloat(str, 64)
+		if err != nil { return nil, err }
+		return f, nil
+	case 's', 'E' :
+		str, err := this.readQuoted()
+		if err != nil { return nil, err }
+		return str, this.expect(';')
+	case 'a' :
+		return this.readArray(depth)
+	case 'O' :
+		return this.readObject(depth)
+	case 'C' :
+		className, err := this.readQuoted()
+		if err != nil { return nil, err }
+		err = this.expect(':')
+		if err != nil { return nil, err }
+		length, err := this.readLength(':')
+		if err != nil { return nil, err }
+		err = this.expect('{')
+		if err != nil { return nil, err }
+		data, err := this.next(length)
+		if err != nil { return nil, err }
+		return map[string]interface{}{phpClassField: className, phpDataField: string(data)},
+			this.expect('}')
+	}
+	return nil, fmt.Errorf("Unknown type %q", typeByte)
+}
+
+// Arrays with the keys 0 to n-1 in order become lists, and others become maps.
+func (this *phpReader) readArray(depth int) (interface{}, error) {
+	keys, vals, err := this.readPairs(depth)
+	if err != nil { return nil, err }
+	isList := true
+	for i, key := range keys {
+		if n, isInt := key.(int64); !isInt || n != int64(i) {
+			isList = false
+			break
+		}
+	}
+	if isList {
+		return vals, nil
+	}
+	arrayMap := make(map[string]interface{}, len(keys))
+	for i, key := range keys {
+		arrayMap[fmt.Sprint(key)] = vals[i]
+	}
+	return arrayMap, nil
+}
+
+func (this *phpReader) readObject(depth int) (interface{}, error) {
+	className, err := this.readQuoted()
+	if err != nil { return nil, err }
+	err = this.expect(':')
+	if err != nil { return nil, err }
+	keys, vals, err := this.readPairs(depth)
+	if err != nil { return nil, err }
+	obj := make(map[string]interface{}, len(keys) + 1)
+	for i, key := range keys {
+		name := fmt.Sprint(key)
+		// Private and protected properties are prefixed with their class, or a
+		// star, between null bytes
+		if strings.HasPrefix(name, "\x00") {
+			if end := strings.IndexByte(name[1:], 0); end >= 0 {
+				name = name[end + 2:]
+			}
+		}
+		obj[name] = vals[i]
+	}
+	obj[phpClassField] = className
+	return obj, nil
+}
+
+// Reads the count and braced key-value pairs of an array or object.
+func (this *phpReader) readPairs(depth int) ([]interface{}, []interface{}, error) {
+	count, err := this.readLength(':')
+	if err != nil { return nil, nil, err }
+	err = this.expect('{')
+	if err != nil { return nil, nil, err }
+	keys := make([]interface{}, 0, count)
+	vals := make([]interface{}, 0, count)
+	for i := 0; i < count; i++ {
+		key, err := this.read(depth + 1)
+		if err != nil { return nil, nil, err }
+		switch key.(type) {
+		case int64, string :
+		default : return nil, nil, errors.New("Array keys must be integers or strings")
+		}
+		val, err := this.read(depth + 1)
+		if err != nil { return nil, nil, err }
+		keys = append(keys, key)
+		vals = append(vals, val)
+	}
+	return keys, vals, this.expect('}')
+}
+
+// Reads a string given as its length in bytes and then the quoted bytes.
+func (this *phpReader) readQuoted() (string, error) {
+	length, err := this.readLength(':')
+	if err != nil { return "", err }
+	err = this.expect('"')
+	if err != nil { return "", err }
+	b, err := this.next(length)
+	if err != nil { return "", err }
+	return string(b), this.expect('"')
+}
+
+// Reads a count or length, which is at most the bytes left since each
+// element takes at least one.
+func (this *phpReader) readLength(delim byte) (int, error) {
+	str, err := this.readUntil(delim)
+	if err != nil { return 0, err }
+	n, err := strconv.Atoi(str)
+	if err != nil { return 0, err }
+	if n < 0 || n > len(this.raw) - this.pos {
+		return 0, TruncatedValueError
+	}
+	return n, nil
+}
+
+func (this *phpReader) readUntil(delim byte) (string, error) {
+	end := bytes.IndexByte(this.raw[this.pos:], delim)
+	if end < 0 {
+		return "", TruncatedValueError
+	}
+	str := string(this.raw[this.pos:this.pos + end])
+	this.pos += end + 1
+	return str, nil
+}
+
+func (this *phpReader) readByte() (byte, error) {
+	b, err := this.next(1)
+	if err != nil { return 0, err }
+	return b[0], nil
+}
+
+func (this *phpReader) expect(expected byte) error {
+	b, err := this.readByte()
+	if err != nil { return err }
+	if b != expected {
+		return fmt.Errorf("Expected %q at %d but found %q", expected, this.pos - 1, b)
+	}
+	return nil
+}
+
+func (this *phpReader) next(length int) ([]byte, error) {
+	if length < 0 || length > len(this.raw) - this.pos {
+		return nil, TruncatedValueError
+	}
+	b := this.raw[this.pos:this.pos + length]
+	this.pos += length
+	return b, nil
+}
\ No newline at end of file
@@ -0,0 +1,107 @@
+package redis
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestPhpDecode(t *testing.T) {
+	tests := []struct {
+		name string
+		raw string
+		want interface{}
+	}{
+		{"null", "N;", nil},
+		{"bool", "b:1;", true},
+		{"int", "i:-42;", int64(-42)},
+		{"float", "d:0.5;", 0.5},
+		{"infinity", "d:INF;", "INF"},
+		{"string", `s:5:"hello";`, "hello"},
+		{"string with quotes", `s:3:"a"b";`, `a"b`},
+		{"list", `a:2:{i:0;s:1:"a";i:1;s:1:"b";}`, []interface{}{"a", "b"}},
+		{"map", `a:2:{s:1:"k";i:1;i:5;b:0;}`, map[string]interface{}{"k": int64(1), "5": false}},
+		{"list out of order", `a:1:{i:1;N;}`, map[string]interface{}{"1": nil}},
+		{"object", "O:3:\"Foo\":2:{s:1:\"a\";i:1;s:6:\"\x00*\x00bar\";i:2;}",
+			map[string]interface{}{phpClassField: "Foo", "a": int64(1), "bar": int64(2)}},
+		{"custom serialized", `C:3:"Foo":3:{abc}`,
+			map[string]interface{}{phpClassField: "Foo", phpDataField: "abc"}},
+		{"reference", `a:2:{i:0;i:1;i:1;R:2;}`,
+			[]interface{}{int64(1), map[string]interface{}{phpRefField: int64(2)}}},
+	}
+	decoder := &phpDecoder{}
+	for _, test := range tests {
+		got, err := decoder.Decode([]byte(test.raw))
+		if err != nil {
+			t.Errorf("%s: %v", test.name, err)
+			continue
+		}
+		if !reflect.DeepEqual(got, test.want) {
+			t.Errorf("%s: got %#v, want %#v", test.name, got, test.want)
+		}
+	}
+}
+
+func TestPhpDecodeInvalid(t *testing.T) {
+	tests := []struct {
+		name string
+		raw string
+		wantTruncated bool
+	}{
+		{"empty", "", true},
+		{"missing terminator", "i:1", true},
+		{"oversized string", `s:100:"hello";`, true},
+		{"negative length", `s:-1:"";`, true},
+		{"oversized array", "a:1000000000:{}", true},
+		{"oversized custom data", `C:3:"Foo":100:{abc}`, true},
+		{"short string", `s:6:"hello";`, false},
+		{"long string", `s:4:"hello";`, false},
+		{"invalid bool", "b:2;", false},
+		{"invalid int", "i:x;", false},
+		{"float key", `a:1:{d:0.5;i:1;}`, false},
+		{"array key", `a:1:{a:0:{}i:1;}`, false},
+		{"missing brace", `a:1:{i:0;i:1;`, true},
+		{"unknown type", "x:1;", false},
+		{"trailing data", "i:1;i:2;", false},
+		{"nested too deeply", nestedPhpArrays(maxDecodeDepth + 1), false},
+	}
+	decoder := &phpDecoder{}
+	for _, test := range tests {
+		_, err := decoder.Decode([]byte(test.raw))
+		if err == nil {
+			t.Errorf("%s: decoded without an error", test.name)
+		} else if test.wantTruncated && err != TruncatedValueError {
+			t.Errorf("%s: error %v, want %v", test.name, err, TruncatedValueError)
+		}
+	}
+	if _, err := decoder.Decode([]byte(nestedPhpArrays(maxDecodeDepth))); err != nil {
+		t.Errorf("nested to the limit: %v", err)
+	}
+}
+
+func TestPhpDetect(t *testing.T) {
+	tests := []struct {
+		raw string
+		want bool
+	}{
+		{"N;", true},
+		{"i:1;", true},
+		{`a:0:{}`, true},
+		{"s:1:", false},
+		{"i:1", false},
+		{"hello", false},
+		{"b:yes", false},
+		{"N", false},
+	}
+	decoder := &phpDecoder{}
+	for _, test := range tests {
+		if got := decoder.Detect([]byte(test.raw)); got != test.want {
+			t.Errorf("Detect(%q) = %v, want %v", test.raw, got, test.want)
+		}
+	}
+}
+
+// Returns arrays nested depth times around a null, so that the null is at that depth.
+func nestedPhpArrays(depth int) string {
+	return strings.Repeat("a:1:{i:0;", depth) + "N;" + strings.Repeat("}", depth)
+}
\ No newline at end of file
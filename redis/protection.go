@@ -0,0 +1,157 @@
+package redis
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"io"
+	"sync"
+	"time"
+
+	"github.com/bencase/revis-service/connections"
+	"github.com/bencase/revis-service/dto"
+)
+
+// Operations that modify data, which protected connections restrict
+const (
+	OpDeleteKeys = "delete-keys"
+	OpRestoreBackup = "restore-backup"
+	// Creating and destroying consumer groups and setting their IDs
+	OpManageStreamGroups = "manage-stream-groups"
+	// Claiming and acknowledging pending stream entries
+	OpUpdatePendingEntries = "update-pending-entries"
+)
+
+// Operations that need confirming whatever the connection's protection, since
+// a pattern delete can't be undone and may reach far more keys than expected
+var alwaysConfirmedOps = map[string]bool{OpDeleteKeys: true}
+
+const confirmationTtl = 2 * time.Minute
+
+var ReadOnlyError = errors.New("The connection is read-only")
+
+// Returned when an operation on a connection that requires confirmation is
+// attempted without a valid token. Repeating the request with the token in
+// Confirmation goes ahead with it. Operations that are always confirmed get
+// no token, since only their preview issues one.
+type ConfirmationRequiredError struct {
+	Confirmation *dto.Confirmation
+}
+func (this *ConfirmationRequiredError) Error() string {
+	action := "Confirm"
+	if this.Confirmation.Token == "" {
+		action = "Preview and confirm"
+	}
+	return action + " the " + this.Confirmation.Operation + " operation on connection " +
+		this.Confirmation.ConnName + " with pattern \"" + this.Confirmation.Pattern + "\""
+}
+
+// Issues single-use tokens, each good for one operation with one pattern and
+// key filter on one connection, which expire if not used soon.
+type confirmationStore struct {
+	mutex sync.Mutex
+	confirmations map[string]*dto.Confirmation
+	expiries map[string]time.Time
+}
+
+func newConfirmationStore() *confirmationStore {
+	return &confirmationStore{confirmations: make(map[string]*dto.Confirmation),
+		expiries: make(map[string]time.Time)}
+}
+
+// The key count is the number of keys the operation was previewed to affect,
+// or 0 if it wasn't previewed.
+func (this *confirmationStore) issue(connName string, operation string,
+		pattern string, filter *dto.KeyFilter, keyCount int) (*dto.Confirmation, error) {
+	tokenBytes := make([]byte, 16)
+	_, err := io.ReadFull(rand.Reader, tokenBytes)
+	if err != nil { return nil, err }
+	expiry := time.Now().Add(confirmationTtl)
+	confirmation := &dto.Confirmation{Token: hex.EncodeToString(tokenBytes),
+		ConnName: connName,
+		Operation: operation,
+		Pattern: pattern,
+		Filter: filter,
+		KeyCount: keyCount,
+		ExpiresAt: expiry.UTC().Format(time.RFC3339)}
+
+	this.mutex.Lock()
+	defer this.mutex.Unlock()
+	this.removeExpired()
+	this.confirmations[confirmation.Token] = confirmation
+	this.expiries[confirmation.Token] = expiry
+	return encodeConfirmation(confirmation), nil
+}
+
+// Returns the confirmation if the token was issued for exactly this
+// operation, using it up, or nil if it wasn't.
+func (this *confirmationStore) redeem(token string, connName string, operation string,
+		pattern string, filter *dto.KeyFilter) *dto.Confirmation {
+	this.mutex.Lock()
+	defer this.mutex.Unlock()
+	this.removeExpired()
+	confirmation, hasToken := this.confirmations[token]
+	if !hasToken || confirmation.ConnName != connName ||
+			confirmation.Operation != operation || confirmation.Pattern != pattern ||
+			!keyFiltersEqual(confirmation.Filter, filter) {
+		return nil
+	}
+	delete(this.confirmations, token)
+	delete(this.expiries, token)
+	return confirmation
+}
+
+func (this *confirmationStore) removeExpired() {
+	now := time.Now()
+	for token, expiry := range this.expiries {
+		if now.After(expiry) {
+			delete(this.confirmations, token)
+			delete(this.expiries, token)
+		}
+	}
+}
+
+// Checks the connection's protection level before an operation that modifies
+// data. Read-only connections reject it, and connections that require
+// confirmation reject it with a new token unless a valid one is given. Always
+// confirmed operations are rejected without a token, so that their preview,
+// which issues it, can't be skipped.
+// Returns the connection, and the confirmation that was used up if one was needed.
+func (this *RedisService) checkWriteAllowed(connName string, operation string,
+		pattern string, filter *dto.KeyFilter, confirmToken string) (*dto.Connection,
+		*dto.Confirmation, error) {
+	conn, err := getProtectedConnection(connName)
+	if err != nil { return nil, nil, err }
+	if conn.Protection != dto.ProtectionConfirm && !alwaysConfirmedOps[operation] {
+		return conn, nil, nil
+	}
+	if confirmToken != "" {
+		confirmation := this.confirmations.redeem(confirmToken, connName, operation, pattern, filter)
+		if confirmation != nil {
+			return conn, confirmation, nil
+		}
+	}
+	if alwaysConfirmedOps[operation] {
+		return nil, nil, &ConfirmationRequiredError{Confirmation: encodeConfirmation(&dto.Confirmation{
+			ConnName: connName,
+			Operation: operation,
+			Pattern: pattern,
+			Filter: filter})}
+	}
+	confirmation, err := this.confirmations.issue(connName, operation, pattern, filter, 0)
+	if err != nil { return nil, nil, err }
+	return nil, nil, &ConfirmationRequiredError{Confirmation: confirmation}
+}
+
+// Returns the connection, or ReadOnlyError if it can't be written to.
+func getProtectedConnection(connName string) (*dto.Connection, error) {
+	conn, err := connections.GetConnectionWithName(connName)
+	if err != nil { return nil, err }
+	if conn == nil {
+		return nil, connections.ConnectionNotFoundError
+	}
+	if conn.Protection == dto.ProtectionReadOnly {
+		return nil, ReadOnlyError
+	}
+	return conn, nil
+}
\ No newline at end of file
@@ -8,7 +8,6 @@ import (
 	"time"
 	
 	"github.com/mediocregopher/radix.v2/redis"
-	rpool "github.com/mediocregopher/radix.v2/pool"
 
 	"github.com/bencase/revis-service/dto"
 	ki "github.com/bencase/revis-service/redis/keyiterator"
@@ -16,6 +15,14 @@ import (
 
 const defaultScanSize = 2000
 const keysToDeleteMaxSize = 50000
+// Keys are unlinked in chunks of this size, so that a cancelled delete stops soon
+const unlinkChunkSize = 1000
+// The number of key names included in a delete preview
+const deletePreviewSampleSize = 20
+// The number of a stream's most recent entries returned with its key
+const streamEntriesLimit = 100
+// The number of a collection's members returned with its key
+const collectionPreviewSize = 100
 // Key types:
 const (
 	typeString = "string"
@@ -23,33 +30,74 @@ const (
 	typeSet = "set"
 	typeZset = "zset"
 	typeHash = "hash"
+	typeStream = "stream"
+	// Returned by TYPE for a key that doesn't exist
+	typeNone = "none"
 )
 
 type RedisCmdRunner interface {
 	io.Closer
-	GetKeysWithValues(pattern string, keyChan chan<- []*dto.Key,
+	GetKeysWithValues(pattern string, decoderRules []*dto.DecoderRule, keyChan chan<- []*dto.Key,
 		finalChan chan<- []*dto.Key, errorChan chan<- error)
-	DeleteKeysMatchingPattern(pattern string) (int, error)
+	DeleteKeysMatchingPattern(pattern string, filter *dto.KeyFilter, status *deleteStatus) error
+	PreviewDeleteKeysMatchingPattern(pattern string, filter *dto.KeyFilter) (*dto.DeletePreview, error)
+	BackUpAndDeleteKeysMatchingPattern(pattern string, filter *dto.KeyFilter,
+		status *deleteStatus, backup *backupWriter) error
+	RestoreKeys(entries []*backupEntry, replace bool) (*restoreCounts, error)
+	GetCollectionPage(key string, cursor string, count int, match string) (*dto.CollectionPage, error)
+	GetStreamGroups(key string) ([]*dto.StreamGroup, error)
+	GetStreamConsumers(key string, group string) ([]*dto.StreamConsumer, error)
+	GetPendingStreamEntries(key string, req *dto.PendingEntriesRequest) ([]*dto.PendingEntry, error)
+	ClaimStreamEntries(key string, req *dto.ClaimStreamEntriesRequest) (*dto.ClaimedStreamEntries, error)
+	AckStreamEntries(key string, group string, ids []string) (int64, error)
+	CreateStreamGroup(key string, group string, id string, mkStream bool) error
+	DestroyStreamGroup(key string, group string) error
+	SetStreamGroupId(key string, group string, id string) error
 	Flush() error
 }
 
 type iRedisCmdRunner struct {
-	pool *rpool.Pool
+	nodes nodeSet
+	// Shared by every client of the pool; nil if the connection doesn't use SSH
+	tunnel *sshTunnel
 }
 
-func getCmdRunner(host string, port string, password string, db int) (RedisCmdRunner, error) {
-	pool, err := rpool.NewCustom("tcp", host + ":" + port, 10, getDialFunc(password, db, 0))
+func getCmdRunner(conn *dto.Connection) (RedisCmdRunner, error) {
+	tunnel, err := getSshTunnel(conn)
 	if err != nil {
 		return nil, err
 	}
-	return &iRedisCmdRunner{pool: pool}, nil
+	nodes, err := getNodeSet(conn, tunnel)
+	if err != nil {
+		if tunnel != nil {
+			tunnel.Close()
+		}
+		return nil, err
+	}
+	return &iRedisCmdRunner{nodes: nodes, tunnel: tunnel}, nil
 }
 
-func (this *iRedisCmdRunner) GetKeysWithValues(pattern string, keyChan chan<- []*dto.Key,
-		finalChan chan<- []*dto.Key, errorChan chan<- error) {
+// Iterates over the keys of every master, which for a cluster means all of its shards.
+func (this *iRedisCmdRunner) newKeyIterator(pattern string) (ki.KeyIterator, error) {
+	return this.newKeyIteratorOfType(pattern, "")
+}
+func (this *iRedisCmdRunner) newKeyIteratorOfType(pattern string, keyType string) (ki.KeyIterator,
+		error) {
+	masters, err := this.nodes.masters()
+	if err != nil { return nil, err }
+	pools := make([]ki.Pool, len(masters))
+	for i, master := range masters {
+		pools[i] = master
+	}
+	return ki.NewMultiNodeKeyIteratorOfType(pools, pattern, keyType)
+}
+
+// String values are decoded for display by the rules, or auto-detected.
+func (this *iRedisCmdRunner) GetKeysWithValues(pattern string, decoderRules []*dto.DecoderRule,
+		keyChan chan<- []*dto.Key, finalChan chan<- []*dto.Key, errorChan chan<- error) {
 	defer recoverFromPanic(keyChan, finalChan, errorChan)
 
-	keyIterator, err := ki.NewKeyIterator(this.pool, pattern)
+	keyIterator, err := this.newKeyIterator(pattern)
 	if err != nil {
 		pushErrorToErrorChan(err, keyChan, finalChan, errorChan)
 		return
@@ -60,13 +108,17 @@ func (this *iRedisCmdRunner) GetKeysWithValues(pattern string, keyChan chan<- []
 	keysScanned := 0
 	for keyIterator.HasNext() && keysScanned < maxTotalKeysPerScan {
 		key, err := keyIterator.Next()
+		// The final scans may turn up no further matches
+		if err == ki.NoMoreElements {
+			break
+		}
 		if err != nil {
 			pushErrorToErrorChan(err, keyChan, finalChan, errorChan)
 			return
 		}
 		keyChunk = append(keyChunk, key)
 		if len(keyChunk) >= defaultLimit {
-			err = this.getMetadataAndValuesForKeys(keyChunk)
+			err = this.getMetadataAndValuesForKeys(keyChunk, decoderRules)
 			if err != nil {
 				pushErrorToErrorChan(err, keyChan, finalChan, errorChan)
 				return
@@ -85,7 +137,7 @@ func (this *iRedisCmdRunner) GetKeysWithValues(pattern string, keyChan chan<- []
 		keysScanned++
 	}
 	if len(keyChunk) > 0 {
-		err = this.getMetadataAndValuesForKeys(keyChunk)
+		err = this.getMetadataAndValuesForKeys(keyChunk, decoderRules)
 		if err != nil {
 			pushErrorToErrorChan(err, keyChan, finalChan, errorChan)
 			return
@@ -96,10 +148,37 @@ func (this *iRedisCmdRunner) GetKeysWithValues(pattern string, keyChan chan<- []
 	finalChan <- keyChunk
 	close(finalChan)
 }
-func (this *iRedisCmdRunner) getMetadataAndValuesForKeys(keys []*dto.Key) error {
-	conn, err := this.pool.Get()
+func (this *iRedisCmdRunner) getMetadataAndValuesForKeys(keys []*dto.Key,
+		decoderRules []*dto.DecoderRule) error {
+	err := this.getMetadataAndValuesForKeysOnNodes(keys)
+	// If a cluster's slots have moved, refresh the mapping and try once more
+	if isRedirectError(err) {
+		err = this.nodes.refresh()
+		if err != nil { return err }
+		err = this.getMetadataAndValuesForKeysOnNodes(keys)
+	}
 	if err != nil { return err }
-	defer this.pool.Put(conn)
+	decodeValues(keys, decoderRules)
+	encodeBinaryKeys(keys)
+	return nil
+}
+func (this *iRedisCmdRunner) getMetadataAndValuesForKeysOnNodes(keys []*dto.Key) error {
+	keysByNode := make(map[connPool][]*dto.Key)
+	for _, key := range keys {
+		pool, err := this.nodes.nodeForKey(key.Key)
+		if err != nil { return err }
+		keysByNode[pool] = append(keysByNode[pool], key)
+	}
+	for pool, nodeKeys := range keysByNode {
+		err := this.getMetadataAndValuesForNodeKeys(pool, nodeKeys)
+		if err != nil { return err }
+	}
+	return nil
+}
+func (this *iRedisCmdRunner) getMetadataAndValuesForNodeKeys(pool connPool, keys []*dto.Key) error {
+	conn, err := pool.Get()
+	if err != nil { return err }
+	defer pool.Put(conn)
 	err = this.addTypesForKeys(conn, keys)
 	if err != nil { return err }
 	err = this.addValuesForKeys(conn, keys)
@@ -126,14 +205,26 @@ func (this *iRedisCmdRunner) addTypesForKeys(conn *redis.Client, keys []*dto.Key
 func (this *iRedisCmdRunner) addValuesForKeys(conn *redis.Client, keys []*dto.Key) error {
 	// Add commands to pipeline
 	for _, key := range keys {
-		// Add the appropriate command for the key's type
+		// Add the appropriate command for the key's type. Types this can't
+		// show, such as those of modules, are left without a value.
 		switch key.Type {
 		case "" : conn.PipeAppend("GET", key.Key)
-		case typeList : conn.PipeAppend("LRANGE", key.Key, 0, -1)
-		case typeSet : conn.PipeAppend("SMEMBERS", key.Key)
-		case typeZset : conn.PipeAppend("ZRANGEBYSCORE", key.Key, "-inf", "+inf", "WITHSCORES")
-		case typeHash : conn.PipeAppend("HGETALL", key.Key)
-		default : conn.PipeAppend("GET", key.Key)
+		// Collections only get a preview of their first members, along with their size
+		case typeList :
+			conn.PipeAppend("LRANGE", key.Key, 0, collectionPreviewSize - 1)
+			conn.PipeAppend("LLEN", key.Key)
+		case typeSet :
+			conn.PipeAppend("SSCAN", key.Key, 0, "COUNT", collectionPreviewSize)
+			conn.PipeAppend("SCARD", key.Key)
+		case typeZset :
+			conn.PipeAppend("ZRANGE", key.Key, 0, collectionPreviewSize - 1, "WITHSCORES")
+			conn.PipeAppend("ZCARD", key.Key)
+		case typeHash :
+			conn.PipeAppend("HSCAN", key.Key, 0, "COUNT", collectionPreviewSize)
+			conn.PipeAppend("HLEN", key.Key)
+		case typeStream :
+			conn.PipeAppend("XINFO", "STREAM", key.Key)
+			conn.PipeAppend("XREVRANGE", key.Key, "+", "-", "COUNT", streamEntriesLimit)
 		}
 		// Also issue a command getting the time-to-live of the key
 		conn.PipeAppend("TTL", key.Key)
@@ -142,18 +233,20 @@ func (this *iRedisCmdRunner) addValuesForKeys(conn *redis.Client, keys []*dto.Ke
 	resps, err := getResponsesFromPipeline(conn)
 	if err != nil { return err }
 	nowSeconds := time.Now().Unix()
-	for i, key := range keys {
-		valResp := resps[i*2]
+	i := 0
+	for _, key := range keys {
 		switch key.Type {
-		case "" : err = this.getValForStringKey(key, valResp)
-		case typeList : err = this.getValForListOrSetKey(key, valResp)
-		case typeSet : err = this.getValForListOrSetKey(key, valResp)
-		case typeZset : err = this.getValForZsetKey(key, valResp)
-		case typeHash : err = this.getValForHashKey(key, valResp)
-		default : err = this.getValForStringKey(key, valResp)
+		case "" : err = this.getValForStringKey(key, resps[i])
+		case typeList : err = this.getValForListKey(key, resps[i], resps[i + 1])
+		case typeSet : err = this.getValForSetKey(key, resps[i], resps[i + 1])
+		case typeZset : err = this.getValForZsetKey(key, resps[i], resps[i + 1])
+		case typeHash : err = this.getValForHashKey(key, resps[i], resps[i + 1])
+		case typeStream : err = this.getValForStreamKey(key, resps[i], resps[i + 1])
 		}
 		if err != nil { return err }
-		ttlResp := resps[i*2+1]
+		i += getValCmdCount(key.Type)
+		ttlResp := resps[i]
+		i++
 		ttl, err := ttlResp.Int64()
 		if err != nil { return err }
 		if ttl > 0 {
@@ -162,45 +255,94 @@ func (this *iRedisCmdRunner) addValuesForKeys(conn *redis.Client, keys []*dto.Ke
 	}
 	return nil
 }
+// The number of commands addValuesForKeys issues for the value of a key of the type.
+func getValCmdCount(typ string) int {
+	switch typ {
+	case "" : return 1
+	case typeList, typeSet, typeZset, typeHash, typeStream : return 2
+	default : return 0
+	}
+}
 func (this *iRedisCmdRunner) getValForStringKey(key *dto.Key, resp *redis.Resp) error {
 	val, err := resp.Str()
 	if err != nil { return err }
 	key.Val = val
 	return nil
 }
-func (this *iRedisCmdRunner) getValForListOrSetKey(key *dto.Key, resp *redis.Resp) error {
+func (this *iRedisCmdRunner) getValForListKey(key *dto.Key, resp *redis.Resp,
+		countResp *redis.Resp) error {
 	vals, err := resp.List()
 	if err != nil { return err }
 	key.Val = vals
-	return nil
+	key.Count, err = countResp.Int64()
+	return err
 }
-func (this *iRedisCmdRunner) getValForZsetKey(key *dto.Key, resp *redis.Resp) error {
+func (this *iRedisCmdRunner) getValForSetKey(key *dto.Key, resp *redis.Resp,
+		countResp *redis.Resp) error {
+	_, vals, err := getScanReply(resp)
+	if err != nil { return err }
+	// The count given to SSCAN is only a hint, so it can return more
+	if len(vals) > collectionPreviewSize {
+		vals = vals[:collectionPreviewSize]
+	}
+	key.Val = vals
+	key.Count, err = countResp.Int64()
+	return err
+}
+func (this *iRedisCmdRunner) getValForZsetKey(key *dto.Key, resp *redis.Resp,
+		countResp *redis.Resp) error {
 	vals, err := resp.List()
 	if err != nil { return err }
+	key.Val, err = getZsetVals(vals)
+	if err != nil { return err }
+	key.Count, err = countResp.Int64()
+	return err
+}
+func (this *iRedisCmdRunner) getValForHashKey(key *dto.Key, resp *redis.Resp,
+		countResp *redis.Resp) error {
+	_, vals, err := getScanReply(resp)
+	if err != nil { return err }
+	if len(vals) > collectionPreviewSize * 2 {
+		vals = vals[:collectionPreviewSize * 2]
+	}
+	key.Val = getHashVals(vals)
+	key.Count, err = countResp.Int64()
+	return err
+}
+func getZsetVals(vals []string) ([]*dto.ZsetVal, error) {
 	var zvals []*dto.ZsetVal
 	// The vals list will be in pairs. The first value will be the member, the second the score.
-	for i := 0; i < len(vals); i = i + 2 {
+	for i := 0; i + 1 < len(vals); i = i + 2 {
 		score, err := strconv.ParseFloat(vals[i + 1], 64)
-		if err != nil { return err }
+		if err != nil { return nil, err }
 		zval := &dto.ZsetVal{Score: score, Zval: vals[i]}
 		zvals = append(zvals, zval)
 	}
-	key.Val = zvals
-	return nil
+	return zvals, nil
 }
-func (this *iRedisCmdRunner) getValForHashKey(key *dto.Key, resp *redis.Resp) error {
-	vals, err := resp.List()
-	if err != nil { return err }
+func getHashVals(vals []string) []*dto.HashVal {
 	var hvals []*dto.HashVal
 	// The vals list will be in pairs. The first will be the key, the second the value.
-	for i := 0; i < len(vals); i = i + 2 {
+	for i := 0; i + 1 < len(vals); i = i + 2 {
 		key := vals[i]
 		val := vals[i + 1]
 		hval := &dto.HashVal{Hkey: key, Hval: val}
 		hvals = append(hvals, hval)
 	}
-	key.Val = hvals
-	return nil
+	return hvals
+}
+// Returns the next cursor and the values of a reply to SCAN, SSCAN, HSCAN or ZSCAN.
+func getScanReply(resp *redis.Resp) (string, []string, error) {
+	parts, err := resp.Array()
+	if err != nil { return "", nil, err }
+	if len(parts) < 2 {
+		return "", nil, errors.New("Unexpected reply to scan")
+	}
+	cursor, err := parts[0].Str()
+	if err != nil { return "", nil, err }
+	vals, err := parts[1].List()
+	if err != nil { return "", nil, err }
+	return cursor, vals, nil
 }
 func pushErrorToErrorChan(err error, keyChan chan<- []*dto.Key, finalChan chan<- []*dto.Key,
 		errorChan chan<- error) {
@@ -212,19 +354,20 @@ func pushErrorToErrorChan(err error, keyChan chan<- []*dto.Key, finalChan chan<-
 }
 
 
-func (this *iRedisCmdRunner) DeleteKeysMatchingPattern(pattern string) (int, error) {
+// Progress is reported through the status, which can also be used to cancel
+// the delete. Only keys that pass the filter are deleted, if one is given.
+// Returns the last error encountered, if any.
+func (this *iRedisCmdRunner) DeleteKeysMatchingPattern(pattern string, filter *dto.KeyFilter,
+		status *deleteStatus) error {
 	wg := &sync.WaitGroup{}
-	status := NewDeleteStatus()
 	wg.Add(1)
-	go this.startDeletingKeys(pattern, wg, status)
+	go this.startDeletingKeys(pattern, filter, wg, status)
 	wg.Wait()
-	count := status.getCount()
-	err := status.getError()
-	return count, err
+	return status.getError()
 }
-func (this *iRedisCmdRunner) startDeletingKeys(pattern string, wg *sync.WaitGroup,
-		status *deleteStatus) {
-	keyIterator, err := ki.NewKeyIterator(this.pool, pattern)
+func (this *iRedisCmdRunner) startDeletingKeys(pattern string, filter *dto.KeyFilter,
+		wg *sync.WaitGroup, status *deleteStatus) {
+	keyIterator, typeFilteredByServer, err := this.newFilteredKeyIterator(pattern, filter)
 	if err != nil {
 		status.setError(err)
 		wg.Done()
@@ -232,54 +375,264 @@ func (this *iRedisCmdRunner) startDeletingKeys(pattern string, wg *sync.WaitGrou
 	}
 	defer keyIterator.Close()
 	keysToDelete := make([]string, 0)
-	for keyIterator.HasNext() {
+	for keyIterator.HasNext() && !status.isCancelled() {
 		key, err := keyIterator.Next()
+		if err == ki.NoMoreElements {
+			break
+		}
 		if err != nil {
 			status.setError(err)
 			wg.Done()
 			return
 		}
+		status.addScanned(1)
 		keysToDelete = append(keysToDelete, key.Key)
-		if len(keysToDelete) >= keysToDeleteMaxSize || !keyIterator.HasNext() {
-			wg.Add(1)
-			go this.delKeysInSlice(keysToDelete, wg, status)
+		// Filtered keys are checked in smaller batches, since each needs a round of commands
+		batchSize := keysToDeleteMaxSize
+		if filter != nil {
+			batchSize = unlinkChunkSize
+		}
+		if len(keysToDelete) >= batchSize || !keyIterator.HasNext() {
+			if !this.dispatchKeysToDelete(keysToDelete, filter, typeFilteredByServer, wg, status) {
+				wg.Done()
+				return
+			}
 			keysToDelete = make([]string, 0)
 		}
 	}
+	if len(keysToDelete) > 0 && !status.isCancelled() {
+		this.dispatchKeysToDelete(keysToDelete, filter, typeFilteredByServer, wg, status)
+	}
 	wg.Done()
 }
-func (this *iRedisCmdRunner) delKeysInSlice(keys []string, wg *sync.WaitGroup,
-		status *deleteStatus) {
-	conn, err := this.pool.Get()
+// Filters the keys and starts deleting the rest. Returns false if the keys
+// couldn't be filtered, in which case none are deleted.
+func (this *iRedisCmdRunner) dispatchKeysToDelete(keys []string, filter *dto.KeyFilter,
+		typeFilteredByServer bool, wg *sync.WaitGroup, status *deleteStatus) bool {
+	keys, counts, err := this.filterKeys(keys, filter, typeFilteredByServer)
 	if err != nil {
 		status.setError(err)
-		wg.Done()
-		return
+		return false
 	}
-	defer this.pool.Put(conn)
-	resp := conn.Cmd("UNLINK", keys)
-	count, err := resp.Int()
-	if err != nil {
-		status.setError(err)
-		wg.Done()
-		return
+	if filter != nil {
+		status.addFiltered(counts)
 	}
-	status.addCount(count)
-	wg.Done()
+	wg.Add(1)
+	go this.delKeysInSlice(keys, wg, status)
+	return true
+}
+func (this *iRedisCmdRunner) delKeysInSlice(keys []string, wg *sync.WaitGroup,
+		status *deleteStatus) {
+	defer wg.Done()
+	for start := 0; start < len(keys) && !status.isCancelled(); start += unlinkChunkSize {
+		end := start + unlinkChunkSize
+		if end > len(keys) {
+			end = len(keys)
+		}
+		chunk := keys[start:end]
+		count, err := this.unlinkKeys(chunk)
+		// If a cluster's slots have moved, refresh the mapping and try once more.
+		// Keys that were already unlinked aren't counted again.
+		if isRedirectError(err) {
+			err = this.nodes.refresh()
+			if err == nil {
+				var retryCount int
+				retryCount, err = this.unlinkKeys(chunk)
+				count += retryCount
+			}
+		}
+		status.addCount(count)
+		if err != nil {
+			status.setError(err)
+		}
+	}
+}
+// Multi-key commands in a cluster may only name keys in the same hash slot, so
+// the keys are grouped by node and then slot, with one UNLINK per slot.
+func (this *iRedisCmdRunner) unlinkKeys(keys []string) (int, error) {
+	keysByNode := make(map[connPool]map[int][]string)
+	for _, key := range keys {
+		pool, err := this.nodes.nodeForKey(key)
+		if err != nil { return 0, err }
+		keysBySlot, hasNode := keysByNode[pool]
+		if !hasNode {
+			keysBySlot = make(map[int][]string)
+			keysByNode[pool] = keysBySlot
+		}
+		slot := this.nodes.slotForKey(key)
+		keysBySlot[slot] = append(keysBySlot[slot], key)
+	}
+	count := 0
+	for pool, keysBySlot := range keysByNode {
+		nodeCount, err := unlinkKeysOnNode(pool, keysBySlot)
+		count += nodeCount
+		if err != nil { return count, err }
+	}
+	return count, nil
+}
+func unlinkKeysOnNode(pool connPool, keysBySlot map[int][]string) (int, error) {
+	conn, err := pool.Get()
+	if err != nil { return 0, err }
+	defer pool.Put(conn)
+	for _, slotKeys := range keysBySlot {
+		conn.PipeAppend("UNLINK", slotKeys)
+	}
+	resps, err := getResponsesFromPipeline(conn)
+	if err != nil { return 0, err }
+	count := 0
+	for _, resp := range resps {
+		slotCount, err := resp.Int()
+		if err != nil { return count, err }
+		count += slotCount
+	}
+	return count, nil
+}
+
+
+// Counts the keys that DeleteKeysMatchingPattern would delete, by type, along
+// with a sample of their names and the number of keys the filter excluded.
+// Nothing is deleted.
+func (this *iRedisCmdRunner) PreviewDeleteKeysMatchingPattern(pattern string,
+		filter *dto.KeyFilter) (*dto.DeletePreview, error) {
+	keyIterator, typeFilteredByServer, err := this.newFilteredKeyIterator(pattern, filter)
+	if err != nil { return nil, err }
+	defer keyIterator.Close()
+	preview := &dto.DeletePreview{SampleKeys: []string{}, Types: make(map[string]int),
+		Filter: filter}
+	if filter != nil {
+		preview.FilterCounts = &dto.FilterCounts{TypeFilteredByServer: typeFilteredByServer}
+	}
+	keyBatch := make([]*dto.Key, 0)
+	for keyIterator.HasNext() {
+		key, err := keyIterator.Next()
+		if err == ki.NoMoreElements {
+			break
+		}
+		if err != nil { return nil, err }
+		keyBatch = append(keyBatch, key)
+		if len(keyBatch) >= defaultScanSize {
+			err = this.addFilteredKeysToPreview(keyBatch, filter, typeFilteredByServer, preview)
+			if err != nil { return nil, err }
+			keyBatch = make([]*dto.Key, 0)
+		}
+	}
+	err = this.addFilteredKeysToPreview(keyBatch, filter, typeFilteredByServer, preview)
+	if err != nil { return nil, err }
+	preview.SampleKeysEncoding = encodeIfBinary(getStrsOfVal(preview.SampleKeys)...)
+	return preview, nil
+}
+func (this *iRedisCmdRunner) addFilteredKeysToPreview(keys []*dto.Key, filter *dto.KeyFilter,
+		typeFilteredByServer bool, preview *dto.DeletePreview) error {
+	if filter == nil {
+		return this.addKeysToPreview(keys, preview)
+	}
+	keyStrs := make([]string, len(keys))
+	for i, key := range keys {
+		keyStrs[i] = key.Key
+	}
+	keyStrs, counts, err := this.filterKeys(keyStrs, filter, typeFilteredByServer)
+	if err != nil { return err }
+	addFilterCounts(preview.FilterCounts, counts)
+	filteredKeys := make([]*dto.Key, len(keyStrs))
+	for i, keyStr := range keyStrs {
+		filteredKeys[i] = &dto.Key{Key: keyStr}
+	}
+	return this.addKeysToPreview(filteredKeys, preview)
+}
+func (this *iRedisCmdRunner) addKeysToPreview(keys []*dto.Key, preview *dto.DeletePreview) error {
+	if len(keys) == 0 {
+		return nil
+	}
+	err := this.addTypesForKeysOnNodes(keys)
+	// If a cluster's slots have moved, refresh the mapping and try once more
+	if isRedirectError(err) {
+		err = this.nodes.refresh()
+		if err != nil { return err }
+		err = this.addTypesForKeysOnNodes(keys)
+	}
+	if err != nil { return err }
+	for _, key := range keys {
+		typ := key.Type
+		if typ == "" {
+			typ = typeString
+		}
+		// Keys that expired or were deleted since they were scanned
+		if typ == typeNone {
+			continue
+		}
+		preview.Count++
+		preview.Types[typ]++
+		if len(preview.SampleKeys) < deletePreviewSampleSize {
+			preview.SampleKeys = append(preview.SampleKeys, key.Key)
+		}
+	}
+	return nil
+}
+func (this *iRedisCmdRunner) addTypesForKeysOnNodes(keys []*dto.Key) error {
+	keysByNode := make(map[connPool][]*dto.Key)
+	for _, key := range keys {
+		pool, err := this.nodes.nodeForKey(key.Key)
+		if err != nil { return err }
+		keysByNode[pool] = append(keysByNode[pool], key)
+	}
+	for pool, nodeKeys := range keysByNode {
+		conn, err := pool.Get()
+		if err != nil { return err }
+		err = this.addTypesForKeys(conn, nodeKeys)
+		pool.Put(conn)
+		if err != nil { return err }
+	}
+	return nil
+}
+
+
+// Runs a command on the node holding the key.
+func (this *iRedisCmdRunner) keyCmd(key string, cmd string, args ...interface{}) (*redis.Resp,
+		error) {
+	resp, err := this.keyCmdOnNode(key, cmd, args...)
+	// If a cluster's slots have moved, refresh the mapping and try once more
+	if isRedirectError(err) {
+		err = this.nodes.refresh()
+		if err != nil { return nil, err }
+		resp, err = this.keyCmdOnNode(key, cmd, args...)
+	}
+	return resp, err
+}
+func (this *iRedisCmdRunner) keyCmdOnNode(key string, cmd string, args ...interface{}) (*redis.Resp,
+		error) {
+	pool, err := this.nodes.nodeForKey(key)
+	if err != nil { return nil, err }
+	conn, err := pool.Get()
+	if err != nil { return nil, err }
+	defer pool.Put(conn)
+	resp := conn.Cmd(cmd, args...)
+	return resp, resp.Err
 }
 
 
 func (this *iRedisCmdRunner) Flush() error {
-	conn, err := this.pool.Get()
+	masters, err := this.nodes.masters()
 	if err != nil { return err }
-	defer this.pool.Put(conn)
+	for _, pool := range masters {
+		err = flushNode(pool)
+		if err != nil { return err }
+	}
+	return nil
+}
+func flushNode(pool connPool) error {
+	conn, err := pool.Get()
+	if err != nil { return err }
+	defer pool.Put(conn)
 	resp := conn.Cmd("FLUSHDB")
 	return resp.Err
 }
 
 
 func (this *iRedisCmdRunner) Close() error {
-	this.pool.Empty()
+	this.nodes.Empty()
+	if this.tunnel != nil {
+		return this.tunnel.Close()
+	}
 	return nil
 }
 
@@ -292,6 +645,8 @@ func getResponsesFromPipeline(conn *redis.Client) ([]*redis.Resp, error) {
 		resp = conn.PipeResp()
 	}
 	if resp.Err != redis.ErrPipelineEmpty {
+		// Discard any unread responses so the connection can be reused
+		conn.PipeClear()
 		return []*redis.Resp{}, resp.Err
 	}
 	return resps, nil
@@ -335,12 +690,65 @@ func recoverFromPanic(keyChan chan<- []*dto.Key,
 }
 
 func NewDeleteStatus() *deleteStatus {
-	return &deleteStatus{mutex: &sync.RWMutex{}}
+	return &deleteStatus{mutex: &sync.RWMutex{}, cancelChan: make(chan struct{})}
 }
 type deleteStatus struct {
 	mutex *sync.RWMutex
+	countScanned int
 	countDeleted int
+	countBackedUp int
+	filterCounts dto.FilterCounts
+	errorCount int
 	err error
+	cancelChan chan struct{}
+	cancelOnce sync.Once
+}
+func (this *deleteStatus) addScanned(count int) {
+	this.mutex.Lock()
+	defer this.mutex.Unlock()
+	this.countScanned += count
+}
+func (this *deleteStatus) getScanned() int {
+	this.mutex.Lock()
+	defer this.mutex.Unlock()
+	return this.countScanned
+}
+func (this *deleteStatus) addBackedUp(count int) {
+	this.mutex.Lock()
+	defer this.mutex.Unlock()
+	this.countBackedUp += count
+}
+func (this *deleteStatus) getBackedUp() int {
+	this.mutex.Lock()
+	defer this.mutex.Unlock()
+	return this.countBackedUp
+}
+func (this *deleteStatus) addFiltered(counts *dto.FilterCounts) {
+	this.mutex.Lock()
+	defer this.mutex.Unlock()
+	addFilterCounts(&this.filterCounts, counts)
+}
+func (this *deleteStatus) getFilterCounts() *dto.FilterCounts {
+	this.mutex.Lock()
+	defer this.mutex.Unlock()
+	counts := this.filterCounts
+	return &counts
+}
+func (this *deleteStatus) getErrorCount() int {
+	this.mutex.Lock()
+	defer this.mutex.Unlock()
+	return this.errorCount
+}
+func (this *deleteStatus) cancel() {
+	this.cancelOnce.Do(func() { close(this.cancelChan) })
+}
+func (this *deleteStatus) isCancelled() bool {
+	select {
+	case <-this.cancelChan :
+		return true
+	default :
+		return false
+	}
 }
 func (this *deleteStatus) addCount(count int) {
 	this.mutex.Lock()
@@ -351,6 +759,7 @@ func (this *deleteStatus) setError(err error) {
 	this.mutex.Lock()
 	defer this.mutex.Unlock()
 	this.err = err
+	this.errorCount++
 }
 func (this *deleteStatus) getCount() int {
 	this.mutex.Lock()
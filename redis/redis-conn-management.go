@@ -1,9 +1,12 @@
 package redis
 
 import (
+	"crypto/tls"
 	"errors"
+	"net"
 	"time"
 
+	rpool "github.com/mediocregopher/radix.v2/pool"
 	"github.com/mediocregopher/radix.v2/redis"
 
 	"github.com/bencase/revis-service/dto"
@@ -11,37 +14,106 @@ import (
 
 
 const defaultTimeout = time.Second * 4;
+const poolSize = 10
 
 
-func getConn(host string, port string, password string, db int,
-		timeout time.Duration) (*redis.Client, error) {
-	dialFunc := getDialFunc(password, db, timeout)
-	return dialFunc("tcp", host + ":" + port)
+// A source of pooled clients for a connection. Implemented by radix's pool
+// for standalone servers and by sentinelPool for sentinel connections.
+type connPool interface {
+	Get() (*redis.Client, error)
+	Put(conn *redis.Client)
+	Empty()
 }
-func getDialFunc(password string, db int, timeout time.Duration) func(network string,
-		addr string) (*redis.Client, error) {
-	return func(network string, addr string) (*redis.Client, error) {
-		var client *redis.Client
+
+// The tunnel is nil if the connection doesn't use SSH.
+func getConnPool(conn *dto.Connection, tunnel *sshTunnel) (connPool, error) {
+	dialFunc, err := getDialFunc(conn, 0, tunnel)
+	if err != nil { return nil, err }
+	if conn.UsesSentinel() {
+		return newSentinelPool(conn, poolSize, dialFunc, tunnel)
+	}
+	network, addr := getNetworkAndAddr(conn)
+	return rpool.NewCustom(network, addr, poolSize, dialFunc)
+}
+
+func getNodeSet(conn *dto.Connection, tunnel *sshTunnel) (nodeSet, error) {
+	if conn.IsCluster() {
+		if conn.Db >= 1 {
+			return nil, errors.New("Redis Cluster only supports database 0")
+		}
+		dialFunc, err := getDialFunc(conn, 0, tunnel)
+		if err != nil { return nil, err }
+		// The configured host is only a seed; the other masters are discovered from it
+		return newClusterNodes(conn.Host + ":" + conn.Port, conn.TlsEnabled(), poolSize, dialFunc)
+	}
+	pool, err := getConnPool(conn, tunnel)
+	if err != nil { return nil, err }
+	return &singleNode{pool: pool}, nil
+}
+
+func getConn(conn *dto.Connection, timeout time.Duration, tunnel *sshTunnel) (*redis.Client, error) {
+	dialFunc, err := getDialFunc(conn, timeout, tunnel)
+	if err != nil { return nil, err }
+	if conn.UsesSentinel() {
+		return getSentinelMasterConn(conn, dialFunc, tunnel)
+	}
+	return dialFunc(getNetworkAndAddr(conn))
+}
+// Returns the network and address to dial for a standalone connection.
+func getNetworkAndAddr(conn *dto.Connection) (string, string) {
+	if conn.UsesSocket() {
+		return "unix", conn.SocketPath
+	}
+	return "tcp", conn.Host + ":" + conn.Port
+}
+func getSentinelMasterConn(conn *dto.Connection, dialFunc rpool.DialFunc,
+		tunnel *sshTunnel) (*redis.Client, error) {
+	sentinelDialer, err := getSentinelDialer(conn, tunnel)
+	if err != nil { return nil, err }
+	masterAddr, err := resolveSentinelMaster(conn.Sentinel.Addrs, conn.Sentinel.MasterName,
+		sentinelDialer)
+	if err != nil { return nil, err }
+	return getMasterCheckingDialFunc(dialFunc)("tcp", masterAddr)
+}
+func getDialFunc(conn *dto.Connection, timeout time.Duration,
+		tunnel *sshTunnel) (rpool.DialFunc, error) {
+	dialer := &connDialer{timeout: timeout, tunnel: tunnel}
+	if conn.TlsEnabled() {
 		var err error
-		if timeout >= 0 {
-			client, err = redis.DialTimeout(network, addr, timeout)
-		} else {
-			client, err = redis.Dial(network, addr)
+		// Sentinel masters and cluster nodes are verified against the addresses
+		// they are discovered at
+		host := conn.Host
+		if conn.UsesSentinel() || conn.IsCluster() {
+			host = ""
 		}
+		dialer.tlsConfig, err = getTlsConfig(conn.Tls, host)
+		if err != nil { return nil, err }
+	}
+	username := conn.Username
+	password := conn.Password
+	db := conn.Db
+	return func(network string, addr string) (*redis.Client, error) {
+		client, err := dialer.dial(network, addr)
 		if err != nil {
 			return nil, err
 		}
 		// If there's not a password or, just return the client
-		if password == "" && db <= 0 {
+		if username == "" && password == "" && db <= 0 {
 			return client, nil
 		}
-		// If there is a password, perform auth with it
-		if password != "" {
-			resp := client.Cmd("AUTH", password)
+		// If there is a password, perform auth with it, including the ACL
+		// username if there is one
+		if password != "" || username != "" {
+			var resp *redis.Resp
+			if username != "" {
+				resp = client.Cmd("AUTH", username, password)
+			} else {
+				resp = client.Cmd("AUTH", password)
+			}
 			err = resp.Err
 			if err != nil {
 				client.Close()
-				return nil, err
+				return nil, getAuthError(err, username != "")
 			}
 		}
 		// If it has a non-zero database, select it
@@ -54,24 +126,105 @@ func getDialFunc(password string, db int, timeout time.Duration) func(network st
 			}
 		}
 		return client, nil
+	}, nil
+}
+
+// Opens clients, tunnelling through SSH and wrapping the connection in TLS if
+// either is configured.
+type connDialer struct {
+	timeout time.Duration
+	tlsConfig *tls.Config
+	tunnel *sshTunnel
+}
+// Configs without a server name, as for sentinel masters and cluster nodes,
+// are given the host of the address being dialed.
+func (this *connDialer) getTlsConfigForAddr(addr string) *tls.Config {
+	if this.tlsConfig.ServerName != "" {
+		return this.tlsConfig
+	}
+	host, _, err := net.SplitHostPort(addr)
+	if err != nil {
+		return this.tlsConfig
 	}
+	tlsConfig := this.tlsConfig.Clone()
+	tlsConfig.ServerName = host
+	return tlsConfig
+}
+func (this *connDialer) dial(network string, addr string) (*redis.Client, error) {
+	timeout := this.timeout
+	if this.tlsConfig == nil && this.tunnel == nil {
+		if timeout >= 0 {
+			return redis.DialTimeout(network, addr, timeout)
+		}
+		return redis.Dial(network, addr)
+	}
+	var netConn net.Conn
+	var err error
+	if this.tunnel != nil {
+		netConn, err = this.tunnel.Dial(network, addr)
+	} else {
+		dialer := &net.Dialer{}
+		if timeout > 0 {
+			dialer.Timeout = timeout
+		}
+		netConn, err = dialer.Dial(network, addr)
+	}
+	if err != nil { return nil, err }
+	if this.tlsConfig != nil {
+		tlsConn := tls.Client(netConn, this.getTlsConfigForAddr(addr))
+		if timeout > 0 {
+			tlsConn.SetDeadline(time.Now().Add(timeout))
+		}
+		err = tlsConn.Handshake()
+		if err != nil {
+			netConn.Close()
+			return nil, err
+		}
+		tlsConn.SetDeadline(time.Time{})
+		netConn = tlsConn
+	}
+	client, err := redis.NewClient(netConn)
+	if err != nil {
+		netConn.Close()
+		return nil, err
+	}
+	if timeout > 0 {
+		client.ReadTimeout = timeout
+		client.WriteTimeout = timeout
+	}
+	return client, nil
 }
 
 
-func TestConn(conn *dto.Connection) error {
-	client, err := getConn(conn.Host, conn.Port, conn.Password, conn.Db, defaultTimeout)
+// Returns the address of the node which answered the test.
+func TestConn(conn *dto.Connection) (string, error) {
+	tunnel, err := getSshTunnel(conn)
 	if err != nil {
-		return err
+		return "", err
+	}
+	if tunnel != nil {
+		defer tunnel.Close()
+	}
+	client, err := getConn(conn, defaultTimeout, tunnel)
+	if err != nil {
+		return "", err
 	}
 	defer client.Close()
 	resp := client.Cmd("PING")
 	str, err := resp.Str()
 	if err != nil {
-		return err
+		return "", ToAclError(err)
 	}
 	if str != "PONG" {
 		logger.Error("Unexpected connection test output:", str)
-		return errors.New("Connection test gave unexpected result")
+		return "", errors.New("Connection test gave unexpected result")
+	}
+	// Make sure a cluster's slots can be discovered through this node
+	if conn.IsCluster() {
+		host, _, err := net.SplitHostPort(client.Addr)
+		if err != nil { return "", err }
+		_, err = discoverClusterSlots(client, host, conn.TlsEnabled())
+		if err != nil { return "", ToAclError(err) }
 	}
-	return nil
+	return client.Addr, nil
 }
\ No newline at end of file
@@ -9,10 +9,23 @@ import (
 type RedisService struct {
 	cmdRunnerRegister *CmdRunnerRegister
 	scanIdChanMap map[int]*chanContainer
+	confirmations *confirmationStore
+	deleteJobs *deleteJobRegister
 }
 
+var InvalidRestoreModeError = errors.New("The restore mode must be " +
+	dto.RestoreSkipExisting + " or " + dto.RestoreReplace)
+var InvalidStreamRequestError = errors.New("A stream key and consumer group must be given")
+var MissingStreamConsumerError = errors.New("A consumer must be given")
+var MissingStreamIdError = errors.New("An ID must be given")
+
 const defaultLimit = 200
 const maxTotalKeysPerScan = 2000
+// The number of collection members in a page when the request doesn't say, and the most there can be
+const defaultPageSize = 100
+const maxPageSize = 10000
+// The number of pending entries listed, or claimed automatically, when the request doesn't say
+const defaultStreamEntriesCount = 100
 
 // It starts at 1 instead of 0 since a 0 may omit the value from the json
 var scanId = 1
@@ -21,7 +34,9 @@ func NewRedisService() *RedisService {
 	cmdRunnerRegister := NewRegister()
 	scanIdChanMap := make(map[int]*chanContainer)
 	redisService := &RedisService{cmdRunnerRegister: cmdRunnerRegister,
-		scanIdChanMap: scanIdChanMap}
+		scanIdChanMap: scanIdChanMap,
+		confirmations: newConfirmationStore(),
+		deleteJobs: newDeleteJobRegister()}
 	return redisService
 }
 
@@ -34,13 +49,15 @@ func (this *RedisService) StartGettingKeysWithValues(connName string, pattern st
 	scanId++
 	
 	cmdRunner, err := this.cmdRunnerRegister.GetCmdRunner(connName)
+	if err != nil { return []*dto.Key{}, id, true, ToAclError(err) }
+	decoderRules, err := getDecoderRules(connName)
 	if err != nil { return []*dto.Key{}, id, true, err }
 	
 	keyChan := make(chan []*dto.Key, maxTotalKeysPerScan / defaultLimit)
 	finalChan := make(chan []*dto.Key)
 	errChan := make(chan error)
 
-	go cmdRunner.GetKeysWithValues(pattern, keyChan, finalChan, errChan)
+	go cmdRunner.GetKeysWithValues(pattern, decoderRules, keyChan, finalChan, errChan)
 
 	chans := &chanContainer{keyChan: keyChan,
 		finalChan: finalChan,
@@ -49,7 +66,7 @@ func (this *RedisService) StartGettingKeysWithValues(connName string, pattern st
 	if hasMoreKeys {
 		this.scanIdChanMap[id] = chans
 	}
-	return keys, id, hasMoreKeys, err
+	return keys, id, hasMoreKeys, ToAclError(err)
 }
 // The bool returned by this function will be true if there are more keys yet to come,
 // or false if there will be no more keys.
@@ -59,10 +76,15 @@ func (this *RedisService) GetNextKeys(id int) ([]*dto.Key, int, bool, error) {
 	if !hasMoreKeys {
 		delete(this.scanIdChanMap, id)
 	}
-	return keys, id, hasMoreKeys, err
+	return keys, id, hasMoreKeys, ToAclError(err)
+}
+
+func (this *RedisService) CloseAllConnections() {
+	this.cmdRunnerRegister.CloseAll()
 }
 
 func (this *RedisService) Close() error {
+	this.deleteJobs.cancelAll()
 	return this.cmdRunnerRegister.Close()
 }
 
@@ -106,22 +128,237 @@ func (this *chanContainer) getNextKeys() ([]*dto.Key, bool, error) {
 	return []*dto.Key{}, false, errors.New("All scan channels are closed")
 }
 
-func (this *RedisService) DeleteKeysMatchingPattern(connName string, pattern string) (bool,
-		int, error) {
+// Starts deleting the keys in the background, returning the job that can be
+// polled for progress. Needs confirmToken to be one issued for this pattern
+// and filter by PreviewDeleteKeysMatchingPattern, so that the count of keys
+// is seen before any are deleted. With backupFirst, the keys are saved to a backup
+// before they're deleted.
+func (this *RedisService) StartDeleteJob(connName string, pattern string, filter *dto.KeyFilter,
+		confirmToken string, backupFirst bool) (*dto.DeleteJob, error) {
 	
+	pattern = normalizeDeletePattern(pattern)
+	filter, err := normalizeKeyFilter(filter)
+	if err != nil { return nil, err }
+	conn, confirmation, err := this.checkWriteAllowed(connName, OpDeleteKeys, pattern, filter,
+		confirmToken)
+	if err != nil { return nil, err }
+	expectedCount := 0
+	if confirmation != nil {
+		expectedCount = confirmation.KeyCount
+	}
+	job, err := this.deleteJobs.start(conn, connName, pattern, filter, expectedCount, backupFirst)
+	return job, ToAclError(err)
+}
+func (this *RedisService) GetDeleteJob(id int) (*dto.DeleteJob, error) {
+	return this.deleteJobs.get(id)
+}
+func (this *RedisService) GetDeleteJobs() []*dto.DeleteJob {
+	return this.deleteJobs.getAll()
+}
+func (this *RedisService) CancelDeleteJob(id int) (*dto.DeleteJob, error) {
+	return this.deleteJobs.cancel(id)
+}
+
+// Counts what a delete job for the pattern would delete, and issues the token
+// StartDeleteJob needs to go ahead.
+func (this *RedisService) PreviewDeleteKeysMatchingPattern(connName string,
+		pattern string, filter *dto.KeyFilter) (*dto.DeletePreview, error) {
+	
+	pattern = normalizeDeletePattern(pattern)
+	filter, err := normalizeKeyFilter(filter)
+	if err != nil { return nil, err }
+	_, err = getProtectedConnection(connName)
+	if err != nil { return nil, err }
 	cmdRunner, err := this.cmdRunnerRegister.GetCmdRunner(connName)
-	if err != nil { return false, 0, err }
-
-	// If the pattern is blank or just a star, execute Flush instead of DeleteKeysMatchingPattern
-	count := 0
-	deletedAllKeys := false;
-	if pattern == "" || pattern == "*" {
-		err = cmdRunner.Flush()
-		if err == nil {
-			deletedAllKeys = true;
+	if err != nil { return nil, ToAclError(err) }
+	
+	preview, err := cmdRunner.PreviewDeleteKeysMatchingPattern(pattern, filter)
+	if err != nil { return nil, ToAclError(err) }
+	preview.Confirmation, err = this.confirmations.issue(connName, OpDeleteKeys, pattern, filter,
+		preview.Count)
+	if err != nil { return nil, err }
+	return preview, nil
+}
+
+// A blank pattern deletes every key, so it's previewed and confirmed as a
+// star, which SCAN matches everything with, rather than as a pattern that
+// matches nothing.
+func normalizeDeletePattern(pattern string) string {
+	if pattern == "" {
+		return "*"
+	}
+	return pattern
+}
+
+func (this *RedisService) GetBackups() ([]*dto.Backup, error) {
+	return listBackups()
+}
+func (this *RedisService) DeleteBackup(id string) error {
+	return deleteBackup(id)
+}
+
+// Restores the keys of a backup into the connection, which needn't be the one
+// they were backed up from. Connections that require confirmation need
+// confirmToken to be one issued for this backup.
+func (this *RedisService) RestoreBackup(req *dto.RestoreBackupRequest,
+		confirmToken string) (*dto.RestoreBackupResponse, error) {
+	replace := false
+	switch req.Mode {
+	case "", dto.RestoreSkipExisting :
+	case dto.RestoreReplace : replace = true
+	default : return nil, InvalidRestoreModeError
+	}
+	_, err := readBackupMeta(req.BackupId)
+	if err != nil { return nil, err }
+	_, _, err = this.checkWriteAllowed(req.ConnName, OpRestoreBackup, req.BackupId, nil, confirmToken)
+	if err != nil { return nil, err }
+	cmdRunner, err := this.cmdRunnerRegister.GetCmdRunner(req.ConnName)
+	if err != nil { return nil, ToAclError(err) }
+
+	resp := &dto.RestoreBackupResponse{}
+	err = readBackup(req.BackupId, unlinkChunkSize, func(meta *backupMeta, entries []*backupEntry) error {
+		counts, err := cmdRunner.RestoreKeys(entries, replace)
+		if counts != nil {
+			resp.Restored += counts.restored
+			resp.Skipped += counts.skipped
+			resp.Expired += counts.expired
 		}
-	} else {
-		count, err = cmdRunner.DeleteKeysMatchingPattern(pattern)
+		return err
+	})
+	if err != nil { return nil, ToAclError(err) }
+	logger.Info("Restored", resp.Restored, "keys from backup", req.BackupId, "into", req.ConnName)
+	return resp, nil
+}
+
+// Gets a page of the members of a list, set, sorted set or hash, starting
+// from the cursor of the previous page.
+func (this *RedisService) GetCollectionPage(connName string, key string, cursor string,
+		count int, match string) (*dto.CollectionPage, error) {
+	if count <= 0 {
+		count = defaultPageSize
+	}
+	if count > maxPageSize {
+		count = maxPageSize
 	}
-	return deletedAllKeys, count, err
+	cmdRunner, err := this.cmdRunnerRegister.GetCmdRunner(connName)
+	if err != nil { return nil, ToAclError(err) }
+	page, err := cmdRunner.GetCollectionPage(key, cursor, count, match)
+	return page, ToAclError(err)
+}
+
+func (this *RedisService) GetStreamGroups(connName string, key string) ([]*dto.StreamGroup, error) {
+	if key == "" {
+		return nil, InvalidStreamRequestError
+	}
+	cmdRunner, err := this.cmdRunnerRegister.GetCmdRunner(connName)
+	if err != nil { return nil, ToAclError(err) }
+	groups, err := cmdRunner.GetStreamGroups(key)
+	return groups, ToAclError(err)
+}
+func (this *RedisService) GetStreamConsumers(connName string, key string,
+		group string) ([]*dto.StreamConsumer, error) {
+	if key == "" || group == "" {
+		return nil, InvalidStreamRequestError
+	}
+	cmdRunner, err := this.cmdRunnerRegister.GetCmdRunner(connName)
+	if err != nil { return nil, ToAclError(err) }
+	consumers, err := cmdRunner.GetStreamConsumers(key, group)
+	return consumers, ToAclError(err)
+}
+func (this *RedisService) GetPendingStreamEntries(connName string, key string,
+		req *dto.PendingEntriesRequest) ([]*dto.PendingEntry, error) {
+	if key == "" || req.Group == "" {
+		return nil, InvalidStreamRequestError
+	}
+	if req.Start == "" {
+		req.Start = "-"
+	}
+	if req.End == "" {
+		req.End = "+"
+	}
+	if req.Count <= 0 {
+		req.Count = defaultStreamEntriesCount
+	}
+	cmdRunner, err := this.cmdRunnerRegister.GetCmdRunner(connName)
+	if err != nil { return nil, ToAclError(err) }
+	entries, err := cmdRunner.GetPendingStreamEntries(key, req)
+	return entries, ToAclError(err)
+}
+
+// Changes to consumer groups and their pending entries are refused by
+// read-only connections, and need confirmToken to be one issued for the
+// stream key on connections that require confirmation.
+func (this *RedisService) ClaimStreamEntries(connName string, key string,
+		req *dto.ClaimStreamEntriesRequest, confirmToken string) (*dto.ClaimedStreamEntries, error) {
+	if key == "" || req.Group == "" {
+		return nil, InvalidStreamRequestError
+	}
+	if req.Consumer == "" {
+		return nil, MissingStreamConsumerError
+	}
+	if req.Start == "" {
+		req.Start = "0-0"
+	}
+	if req.Count <= 0 {
+		req.Count = defaultStreamEntriesCount
+	}
+	cmdRunner, err := this.getStreamCmdRunner(connName, OpUpdatePendingEntries, key, confirmToken)
+	if err != nil { return nil, err }
+	claimed, err := cmdRunner.ClaimStreamEntries(key, req)
+	return claimed, ToAclError(err)
+}
+func (this *RedisService) AckStreamEntries(connName string, key string,
+		req *dto.AckStreamEntriesRequest, confirmToken string) (int64, error) {
+	if key == "" || req.Group == "" {
+		return 0, InvalidStreamRequestError
+	}
+	if len(req.Ids) == 0 {
+		return 0, nil
+	}
+	cmdRunner, err := this.getStreamCmdRunner(connName, OpUpdatePendingEntries, key, confirmToken)
+	if err != nil { return 0, err }
+	acked, err := cmdRunner.AckStreamEntries(key, req.Group, req.Ids)
+	return acked, ToAclError(err)
+}
+// The ID defaults to "$", so that the group only reads new entries.
+func (this *RedisService) CreateStreamGroup(connName string, key string,
+		req *dto.StreamGroupRequest, confirmToken string) error {
+	if key == "" || req.Group == "" {
+		return InvalidStreamRequestError
+	}
+	id := req.Id
+	if id == "" {
+		id = "$"
+	}
+	cmdRunner, err := this.getStreamCmdRunner(connName, OpManageStreamGroups, key, confirmToken)
+	if err != nil { return err }
+	return ToAclError(cmdRunner.CreateStreamGroup(key, req.Group, id, req.MkStream))
+}
+func (this *RedisService) DestroyStreamGroup(connName string, key string, group string,
+		confirmToken string) error {
+	if key == "" || group == "" {
+		return InvalidStreamRequestError
+	}
+	cmdRunner, err := this.getStreamCmdRunner(connName, OpManageStreamGroups, key, confirmToken)
+	if err != nil { return err }
+	return ToAclError(cmdRunner.DestroyStreamGroup(key, group))
+}
+func (this *RedisService) SetStreamGroupId(connName string, key string,
+		req *dto.StreamGroupRequest, confirmToken string) error {
+	if key == "" || req.Group == "" {
+		return InvalidStreamRequestError
+	}
+	if req.Id == "" {
+		return MissingStreamIdError
+	}
+	cmdRunner, err := this.getStreamCmdRunner(connName, OpManageStreamGroups, key, confirmToken)
+	if err != nil { return err }
+	return ToAclError(cmdRunner.SetStreamGroupId(key, req.Group, req.Id))
+}
+func (this *RedisService) getStreamCmdRunner(connName string, operation string, key string,
+		confirmToken string) (RedisCmdRunner, error) {
+	_, _, err := this.checkWriteAllowed(connName, operation, key, nil, confirmToken)
+	if err != nil { return nil, err }
+	cmdRunner, err := this.cmdRunnerRegister.GetCmdRunner(connName)
+	return cmdRunner, ToAclError(err)
 }
\ No newline at end of file
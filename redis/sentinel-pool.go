@@ -0,0 +1,229 @@
+package redis
+
+import (
+	"errors"
+	"net"
+	"strings"
+	"sync"
+	"time"
+
+	rpool "github.com/mediocregopher/radix.v2/pool"
+	"github.com/mediocregopher/radix.v2/redis"
+
+	"github.com/bencase/revis-service/dto"
+)
+
+// How long a resolved master address is trusted before the sentinels are asked again
+const sentinelRecheckInterval = 5 * time.Second
+
+var NotMasterError = errors.New("The node is not the master; a failover may be in progress")
+
+// sentinelPool hands out connections to whichever node the sentinels currently
+// report as the master, replacing its inner pool when the master changes.
+type sentinelPool struct {
+	sentinelAddrs []string
+	masterName string
+	poolSize int
+	dialFunc rpool.DialFunc
+	sentinelDialer *connDialer
+
+	mutex *sync.Mutex
+	masterAddr string
+	pool *rpool.Pool
+	lastResolved time.Time
+	// Tracks which pool each checked-out connection came from, since the
+	// pool may be replaced while the connection is in use
+	checkedOut map[*redis.Client]*rpool.Pool
+}
+
+func newSentinelPool(conn *dto.Connection, poolSize int,
+		dialFunc rpool.DialFunc, tunnel *sshTunnel) (*sentinelPool, error) {
+	sentinelDialer, err := getSentinelDialer(conn, tunnel)
+	if err != nil { return nil, err }
+	sp := &sentinelPool{sentinelAddrs: conn.Sentinel.Addrs,
+		masterName: conn.Sentinel.MasterName,
+		poolSize: poolSize,
+		dialFunc: dialFunc,
+		sentinelDialer: sentinelDialer,
+		mutex: &sync.Mutex{},
+		checkedOut: make(map[*redis.Client]*rpool.Pool)}
+	// Resolve the master up front so that a misconfiguration is reported immediately
+	_, err = sp.getMasterPool()
+	if err != nil { return nil, err }
+	return sp, nil
+}
+
+func (this *sentinelPool) Get() (*redis.Client, error) {
+	pool, err := this.getMasterPool()
+	if err != nil { return nil, err }
+	conn, err := pool.Get()
+	if err != nil {
+		// The master may have failed over since it was last resolved, so
+		// ask the sentinels again before giving up
+		this.invalidate(pool)
+		pool, err = this.getMasterPool()
+		if err != nil { return nil, err }
+		conn, err = pool.Get()
+		if err != nil { return nil, err }
+	}
+	this.mutex.Lock()
+	defer this.mutex.Unlock()
+	this.checkedOut[conn] = pool
+	return conn, nil
+}
+
+func (this *sentinelPool) Put(conn *redis.Client) {
+	this.mutex.Lock()
+	defer this.mutex.Unlock()
+	pool := this.checkedOut[conn]
+	delete(this.checkedOut, conn)
+	if pool != nil && pool == this.pool {
+		pool.Put(conn)
+	} else {
+		// Connections to a previous master are not reused
+		conn.Close()
+	}
+}
+
+func (this *sentinelPool) Empty() {
+	this.mutex.Lock()
+	defer this.mutex.Unlock()
+	if this.pool != nil {
+		this.pool.Empty()
+		this.pool = nil
+	}
+}
+
+// Returns the pool for the current master, re-resolving the master with the
+// sentinels if the last resolution is too old.
+func (this *sentinelPool) getMasterPool() (*rpool.Pool, error) {
+	this.mutex.Lock()
+	defer this.mutex.Unlock()
+	if this.pool != nil && time.Since(this.lastResolved) < sentinelRecheckInterval {
+		return this.pool, nil
+	}
+	masterAddr, err := resolveSentinelMaster(this.sentinelAddrs, this.masterName,
+		this.sentinelDialer)
+	if err != nil {
+		// If the sentinels can't be reached, keep using the last known master
+		if this.pool != nil {
+			logger.Warning("Could not re-resolve master", this.masterName + ":", err)
+			return this.pool, nil
+		}
+		return nil, err
+	}
+	this.lastResolved = time.Now()
+	if this.pool != nil && masterAddr == this.masterAddr {
+		return this.pool, nil
+	}
+	pool, err := rpool.NewCustom("tcp", masterAddr, this.poolSize,
+		getMasterCheckingDialFunc(this.dialFunc))
+	if err != nil { return nil, err }
+	if this.pool != nil {
+		logger.Info("Master", this.masterName, "moved from", this.masterAddr, "to", masterAddr)
+		this.pool.Empty()
+	}
+	this.masterAddr = masterAddr
+	this.pool = pool
+	return pool, nil
+}
+
+// Drops the connections to the master, idle ones straight away and checked
+// out ones when they're put back, so that the next Get resolves the master
+// again. Called when the master answers READONLY, as it does once demoted.
+func (this *sentinelPool) refresh() {
+	this.mutex.Lock()
+	defer this.mutex.Unlock()
+	this.lastResolved = time.Time{}
+	if this.pool != nil {
+		this.pool.Empty()
+		this.pool = nil
+	}
+}
+
+func (this *sentinelPool) invalidate(pool *rpool.Pool) {
+	this.mutex.Lock()
+	defer this.mutex.Unlock()
+	if this.pool == pool {
+		this.lastResolved = time.Time{}
+	}
+}
+
+// Checks the sentinel settings and builds the dialer for talking to the
+// sentinels themselves.
+func getSentinelDialer(conn *dto.Connection, tunnel *sshTunnel) (*connDialer, error) {
+	if len(conn.Sentinel.Addrs) == 0 || conn.Sentinel.MasterName == "" {
+		return nil, errors.New("A sentinel connection needs at least one sentinel address and a master name")
+	}
+	dialer := &connDialer{timeout: defaultTimeout, tunnel: tunnel}
+	if conn.TlsEnabled() {
+		var err error
+		dialer.tlsConfig, err = getTlsConfig(conn.Tls, "")
+		if err != nil { return nil, err }
+	}
+	return dialer, nil
+}
+
+// Asks each sentinel in turn for the address of the named master.
+func resolveSentinelMaster(sentinelAddrs []string, masterName string,
+		dialer *connDialer) (string, error) {
+	var errMsgs []string
+	for _, sentinelAddr := range sentinelAddrs {
+		masterAddr, err := querySentinelForMaster(sentinelAddr, masterName, dialer)
+		if err == nil {
+			return masterAddr, nil
+		}
+		errMsgs = append(errMsgs, sentinelAddr + ": " + err.Error())
+	}
+	return "", errors.New("Could not resolve master " + masterName +
+		" from any sentinel (" + strings.Join(errMsgs, "; ") + ")")
+}
+func querySentinelForMaster(sentinelAddr string, masterName string,
+		dialer *connDialer) (string, error) {
+	client, err := dialer.dial("tcp", sentinelAddr)
+	if err != nil { return "", err }
+	defer client.Close()
+	resp := client.Cmd("SENTINEL", "get-master-addr-by-name", masterName)
+	if resp.IsType(redis.Nil) {
+		return "", errors.New("sentinel does not monitor a master with that name")
+	}
+	hostAndPort, err := resp.List()
+	if err != nil { return "", err }
+	if len(hostAndPort) != 2 {
+		return "", errors.New("sentinel gave an unexpected master address")
+	}
+	return net.JoinHostPort(hostAndPort[0], hostAndPort[1]), nil
+}
+
+// Wraps a dial function so that connections to a node which is no longer the
+// master are refused.
+func getMasterCheckingDialFunc(dialFunc rpool.DialFunc) rpool.DialFunc {
+	return func(network string, addr string) (*redis.Client, error) {
+		client, err := dialFunc(network, addr)
+		if err != nil { return nil, err }
+		err = checkIsMaster(client)
+		if err != nil {
+			client.Close()
+			return nil, err
+		}
+		return client, nil
+	}
+}
+func checkIsMaster(client *redis.Client) error {
+	resp := client.Cmd("ROLE")
+	if resp.IsType(redis.IOErr) {
+		return resp.Err
+	}
+	resps, err := resp.Array()
+	// Servers without the ROLE command, or users not permitted to run it,
+	// can't be checked, so they're given the benefit of the doubt
+	if err != nil || len(resps) == 0 {
+		return nil
+	}
+	role, err := resps[0].Str()
+	if err != nil { return nil }
+	if role != "master" {
+		return NotMasterError
+	}
+	return nil
+}
\ No newline at end of file
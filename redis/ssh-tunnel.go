@@ -0,0 +1,198 @@
+package redis
+
+import (
+	"errors"
+	"io"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/mitchellh/go-homedir"
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/knownhosts"
+
+	"github.com/bencase/revis-service/dto"
+)
+
+const defaultSshPort = "22"
+const defaultKnownHostsFile = "~/.ssh/known_hosts"
+const sshKeepAliveInterval = 30 * time.Second
+
+var SshTunnelClosedError = errors.New("The SSH tunnel has been closed")
+
+// sshTunnel is a connection to a bastion host which every client of a
+// connection's pool is dialed through. If the bastion connection drops, it is
+// re-established on the next dial.
+type sshTunnel struct {
+	addr string
+	config *ssh.ClientConfig
+
+	mutex *sync.Mutex
+	client *ssh.Client
+	closed bool
+	stopChan chan struct{}
+}
+
+// Returns nil without error if the connection doesn't use a tunnel.
+func getSshTunnel(conn *dto.Connection) (*sshTunnel, error) {
+	if !conn.SshEnabled() {
+		return nil, nil
+	}
+	config, err := getSshClientConfig(conn.Ssh)
+	if err != nil { return nil, err }
+	port := conn.Ssh.Port
+	if port == "" {
+		port = defaultSshPort
+	}
+	tunnel := &sshTunnel{addr: net.JoinHostPort(conn.Ssh.Host, port),
+		config: config,
+		mutex: &sync.Mutex{},
+		stopChan: make(chan struct{})}
+	// Connect up front so that bad credentials are reported immediately
+	_, err = tunnel.getClient()
+	if err != nil { return nil, err }
+	go tunnel.keepAlive()
+	return tunnel, nil
+}
+
+func getSshClientConfig(sshConfig *dto.SshTunnelConfig) (*ssh.ClientConfig, error) {
+	if sshConfig.Host == "" || sshConfig.User == "" {
+		return nil, errors.New("An SSH tunnel needs a host and a user")
+	}
+	var authMethods []ssh.AuthMethod
+	if sshConfig.PrivateKey != "" {
+		var signer ssh.Signer
+		var err error
+		if sshConfig.Passphrase != "" {
+			signer, err = ssh.ParsePrivateKeyWithPassphrase([]byte(sshConfig.PrivateKey),
+				[]byte(sshConfig.Passphrase))
+		} else {
+			signer, err = ssh.ParsePrivateKey([]byte(sshConfig.PrivateKey))
+		}
+		if err != nil { return nil, errors.New("Could not parse SSH private key: " + err.Error()) }
+		authMethods = append(authMethods, ssh.PublicKeys(signer))
+	}
+	if sshConfig.Password != "" {
+		authMethods = append(authMethods, ssh.Password(sshConfig.Password))
+	}
+	if len(authMethods) == 0 {
+		return nil, errors.New("An SSH tunnel needs a private key or a password")
+	}
+	hostKeyCallback, err := getHostKeyCallback(sshConfig)
+	if err != nil { return nil, err }
+	return &ssh.ClientConfig{User: sshConfig.User,
+		Auth: authMethods,
+		HostKeyCallback: hostKeyCallback,
+		Timeout: defaultTimeout}, nil
+}
+func getHostKeyCallback(sshConfig *dto.SshTunnelConfig) (ssh.HostKeyCallback, error) {
+	if sshConfig.SkipHostKeyCheck {
+		return ssh.InsecureIgnoreHostKey(), nil
+	}
+	knownHostsFile := sshConfig.KnownHostsFile
+	if knownHostsFile == "" {
+		knownHostsFile = defaultKnownHostsFile
+	}
+	knownHostsFile, err := homedir.Expand(knownHostsFile)
+	if err != nil { return nil, err }
+	hostKeyCallback, err := knownhosts.New(knownHostsFile)
+	if err != nil {
+		return nil, errors.New("Could not read SSH known hosts file: " + err.Error())
+	}
+	return hostKeyCallback, nil
+}
+
+// Opens a connection to the address as seen from the bastion host.
+func (this *sshTunnel) Dial(network string, addr string) (net.Conn, error) {
+	client, err := this.getClient()
+	if err != nil { return nil, err }
+	netConn, err := client.Dial(network, addr)
+	if isSshTransportError(err) {
+		// The bastion connection may have dropped, so reconnect and try once more
+		this.dropClient(client)
+		client, err = this.getClient()
+		if err != nil { return nil, err }
+		return client.Dial(network, addr)
+	}
+	return netConn, err
+}
+
+// True if the error is from the bastion connection itself rather than the
+// bastion refusing to open a channel, as it does when the address is down.
+// Only the former is worth reconnecting for, since closing the connection
+// closes every client tunnelled through it.
+func isSshTransportError(err error) bool {
+	if err == nil {
+		return false
+	}
+	if _, isOpenErr := err.(*ssh.OpenChannelError); isOpenErr {
+		return false
+	}
+	_, isNetErr := err.(net.Error)
+	return isNetErr || err == io.EOF
+}
+
+func (this *sshTunnel) getClient() (*ssh.Client, error) {
+	this.mutex.Lock()
+	defer this.mutex.Unlock()
+	if this.closed {
+		return nil, SshTunnelClosedError
+	}
+	if this.client != nil {
+		return this.client, nil
+	}
+	client, err := ssh.Dial("tcp", this.addr, this.config)
+	if err != nil { return nil, err }
+	this.client = client
+	return client, nil
+}
+
+func (this *sshTunnel) dropClient(client *ssh.Client) {
+	this.mutex.Lock()
+	defer this.mutex.Unlock()
+	if this.client == client {
+		this.client.Close()
+		this.client = nil
+	}
+}
+
+// Periodically sends a request over the bastion connection so that idle
+// tunnels aren't dropped, and so that dead ones are noticed.
+func (this *sshTunnel) keepAlive() {
+	ticker := time.NewTicker(sshKeepAliveInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-this.stopChan:
+			return
+		case <-ticker.C:
+			this.mutex.Lock()
+			client := this.client
+			this.mutex.Unlock()
+			if client == nil {
+				continue
+			}
+			_, _, err := client.SendRequest("keepalive@openssh.com", true, nil)
+			if err != nil {
+				logger.Warning("SSH keepalive to", this.addr, "failed:", err)
+				this.dropClient(client)
+			}
+		}
+	}
+}
+
+func (this *sshTunnel) Close() error {
+	this.mutex.Lock()
+	defer this.mutex.Unlock()
+	if this.closed {
+		return nil
+	}
+	this.closed = true
+	close(this.stopChan)
+	if this.client != nil {
+		err := this.client.Close()
+		this.client = nil
+		return err
+	}
+	return nil
+}
\ No newline at end of file
@@ -0,0 +1,280 @@
+package redis
+
+import (
+	"errors"
+	"strings"
+
+	"github.com/mediocregopher/radix.v2/redis"
+
+	"github.com/bencase/revis-service/dto"
+)
+
+// Takes the replies of XINFO STREAM and of XREVRANGE for the stream's most
+// recent entries.
+func (this *iRedisCmdRunner) getValForStreamKey(key *dto.Key, infoResp *redis.Resp,
+		entriesResp *redis.Resp) error {
+	info, err := respToMap(infoResp)
+	if err != nil { return err }
+	streamVal := &dto.StreamVal{}
+	if resp, hasLength := info["length"]; hasLength {
+		streamVal.Length, err = resp.Int64()
+		if err != nil { return err }
+	}
+	if resp, hasGroups := info["groups"]; hasGroups {
+		streamVal.Groups, err = resp.Int64()
+		if err != nil { return err }
+	}
+	// The first and last entries are nil for an empty stream
+	streamVal.FirstId = getEntryIdFromResp(info["first-entry"])
+	streamVal.LastId = getEntryIdFromResp(info["last-entry"])
+	streamVal.Entries, err = getStreamEntriesFromResp(entriesResp)
+	if err != nil { return err }
+	key.Val = streamVal
+	return nil
+}
+
+// Returns the ID of an entry reply of an ID and its fields, or a blank ID if
+// there's no entry.
+func getEntryIdFromResp(resp *redis.Resp) string {
+	if resp == nil {
+		return ""
+	}
+	entryResps, err := resp.Array()
+	if err != nil || len(entryResps) == 0 {
+		return ""
+	}
+	id, err := entryResps[0].Str()
+	if err != nil {
+		return ""
+	}
+	return id
+}
+
+// Parses a reply of entries, each an ID and a list of alternating fields and
+// values, as given by XRANGE and XREVRANGE. Entries that have been deleted
+// can be nil in some replies, and are left out.
+func getStreamEntriesFromResp(resp *redis.Resp) ([]*dto.StreamEntry, error) {
+	entryResps, err := resp.Array()
+	if err != nil { return nil, err }
+	entries := make([]*dto.StreamEntry, 0, len(entryResps))
+	for _, entryResp := range entryResps {
+		if entryResp.IsType(redis.Nil) {
+			continue
+		}
+		parts, err := entryResp.Array()
+		if err != nil { return nil, err }
+		if len(parts) < 2 || parts[1].IsType(redis.Nil) {
+			continue
+		}
+		id, err := parts[0].Str()
+		if err != nil { return nil, err }
+		vals, err := parts[1].List()
+		if err != nil { return nil, err }
+		entry := &dto.StreamEntry{Id: id, Fields: make([]*dto.StreamField, 0, len(vals) / 2)}
+		// The vals list will be in pairs. The first will be the field, the second the value.
+		for i := 0; i + 1 < len(vals); i = i + 2 {
+			entry.Fields = append(entry.Fields, &dto.StreamField{Field: vals[i], Value: vals[i + 1]})
+		}
+		entries = append(entries, entry)
+	}
+	return entries, nil
+}
+
+// Returned when a command names a consumer group that doesn't exist, or when
+// creating one that already exists.
+type StreamGroupError struct {
+	Message string
+	Exists bool
+}
+func (this *StreamGroupError) Error() string {
+	return this.Message
+}
+
+// Converts the errors Redis gives for missing and existing groups.
+func toStreamGroupError(err error) error {
+	if err == nil {
+		return nil
+	}
+	message := err.Error()
+	switch {
+	case strings.HasPrefix(message, "NOGROUP") :
+		return &StreamGroupError{Message: message}
+	case strings.HasPrefix(message, "BUSYGROUP") :
+		return &StreamGroupError{Message: message, Exists: true}
+	}
+	return err
+}
+
+// Runs a command on the node holding the stream, with errors for missing and
+// existing groups converted.
+func (this *iRedisCmdRunner) streamCmd(key string, cmd string, args ...interface{}) (*redis.Resp,
+		error) {
+	resp, err := this.keyCmd(key, cmd, args...)
+	return resp, toStreamGroupError(err)
+}
+
+func (this *iRedisCmdRunner) GetStreamGroups(key string) ([]*dto.StreamGroup, error) {
+	resp, err := this.streamCmd(key, "XINFO", "GROUPS", key)
+	if err != nil { return nil, err }
+	groupResps, err := resp.Array()
+	if err != nil { return nil, err }
+	groups := make([]*dto.StreamGroup, 0, len(groupResps))
+	for _, groupResp := range groupResps {
+		info, err := respToMap(groupResp)
+		if err != nil { return nil, err }
+		group := &dto.StreamGroup{Name: getStrFromRespMap(info, "name"),
+			Consumers: getInt64FromRespMap(info, "consumers"),
+			Pending: getInt64FromRespMap(info, "pending"),
+			LastDeliveredId: getStrFromRespMap(info, "last-delivered-id"),
+			EntriesRead: getOptionalInt64FromRespMap(info, "entries-read"),
+			Lag: getOptionalInt64FromRespMap(info, "lag")}
+		groups = append(groups, group)
+	}
+	return groups, nil
+}
+
+func (this *iRedisCmdRunner) GetStreamConsumers(key string, group string) ([]*dto.StreamConsumer,
+		error) {
+	resp, err := this.streamCmd(key, "XINFO", "CONSUMERS", key, group)
+	if err != nil { return nil, err }
+	consumerResps, err := resp.Array()
+	if err != nil { return nil, err }
+	consumers := make([]*dto.StreamConsumer, 0, len(consumerResps))
+	for _, consumerResp := range consumerResps {
+		info, err := respToMap(consumerResp)
+		if err != nil { return nil, err }
+		consumer := &dto.StreamConsumer{Name: getStrFromRespMap(info, "name"),
+			Pending: getInt64FromRespMap(info, "pending"),
+			Idle: getInt64FromRespMap(info, "idle"),
+			Inactive: getOptionalInt64FromRespMap(info, "inactive")}
+		consumers = append(consumers, consumer)
+	}
+	return consumers, nil
+}
+
+// Filtering by idle time needs Redis 6.2 or later.
+func (this *iRedisCmdRunner) GetPendingStreamEntries(key string,
+		req *dto.PendingEntriesRequest) ([]*dto.PendingEntry, error) {
+	args := []interface{}{key, req.Group}
+	if req.MinIdleMs > 0 {
+		args = append(args, "IDLE", req.MinIdleMs)
+	}
+	args = append(args, req.Start, req.End, req.Count)
+	if req.Consumer != "" {
+		args = append(args, req.Consumer)
+	}
+	resp, err := this.streamCmd(key, "XPENDING", args...)
+	if err != nil { return nil, err }
+	entryResps, err := resp.Array()
+	if err != nil { return nil, err }
+	entries := make([]*dto.PendingEntry, 0, len(entryResps))
+	// Each entry is its ID, consumer, idle time and number of deliveries
+	for _, entryResp := range entryResps {
+		parts, err := entryResp.Array()
+		if err != nil { return nil, err }
+		if len(parts) < 4 {
+			return nil, errors.New("Unexpected reply to XPENDING")
+		}
+		entry := &dto.PendingEntry{}
+		entry.Id, err = parts[0].Str()
+		if err != nil { return nil, err }
+		entry.Consumer, err = parts[1].Str()
+		if err != nil { return nil, err }
+		entry.Idle, err = parts[2].Int64()
+		if err != nil { return nil, err }
+		entry.Deliveries, err = parts[3].Int64()
+		if err != nil { return nil, err }
+		entries = append(entries, entry)
+	}
+	return entries, nil
+}
+
+// Uses XCLAIM when IDs are given and XAUTOCLAIM otherwise, which needs Redis
+// 6.2 or later.
+func (this *iRedisCmdRunner) ClaimStreamEntries(key string,
+		req *dto.ClaimStreamEntriesRequest) (*dto.ClaimedStreamEntries, error) {
+	claimed := &dto.ClaimedStreamEntries{}
+	if len(req.Ids) > 0 {
+		resp, err := this.streamCmd(key, "XCLAIM", key, req.Group, req.Consumer, req.MinIdleMs, req.Ids)
+		if err != nil { return nil, err }
+		claimed.Entries, err = getStreamEntriesFromResp(resp)
+		if err != nil { return nil, err }
+		claimed.Encoding = encodeIfBinary(getStrsOfStreamEntries(claimed.Entries)...)
+		return claimed, nil
+	}
+	resp, err := this.streamCmd(key, "XAUTOCLAIM", key, req.Group, req.Consumer, req.MinIdleMs,
+		req.Start, "COUNT", req.Count)
+	if err != nil { return nil, err }
+	// The next ID to start from, the claimed entries and, from Redis 7 on,
+	// the IDs of entries that were deleted
+	parts, err := resp.Array()
+	if err != nil { return nil, err }
+	if len(parts) < 2 {
+		return nil, errors.New("Unexpected reply to XAUTOCLAIM")
+	}
+	claimed.NextId, err = parts[0].Str()
+	if err != nil { return nil, err }
+	claimed.Entries, err = getStreamEntriesFromResp(parts[1])
+	if err != nil { return nil, err }
+	claimed.Encoding = encodeIfBinary(getStrsOfStreamEntries(claimed.Entries)...)
+	if len(parts) > 2 {
+		claimed.DeletedIds, err = parts[2].List()
+		if err != nil { return nil, err }
+	}
+	return claimed, nil
+}
+
+// Returns the number of entries that were acknowledged, which leaves out
+// those that weren't pending.
+func (this *iRedisCmdRunner) AckStreamEntries(key string, group string, ids []string) (int64,
+		error) {
+	resp, err := this.streamCmd(key, "XACK", key, group, ids)
+	if err != nil { return 0, err }
+	return resp.Int64()
+}
+
+func (this *iRedisCmdRunner) CreateStreamGroup(key string, group string, id string,
+		mkStream bool) error {
+	args := []interface{}{"CREATE", key, group, id}
+	if mkStream {
+		args = append(args, "MKSTREAM")
+	}
+	_, err := this.streamCmd(key, "XGROUP", args...)
+	return err
+}
+
+func (this *iRedisCmdRunner) DestroyStreamGroup(key string, group string) error {
+	resp, err := this.streamCmd(key, "XGROUP", "DESTROY", key, group)
+	if err != nil { return err }
+	destroyed, err := resp.Int()
+	if err != nil { return err }
+	if destroyed == 0 {
+		return &StreamGroupError{Message: "No consumer group named " + group + " on stream " + key}
+	}
+	return nil
+}
+
+func (this *iRedisCmdRunner) SetStreamGroupId(key string, group string, id string) error {
+	_, err := this.streamCmd(key, "XGROUP", "SETID", key, group, id)
+	return err
+}
+
+func getInt64FromRespMap(mp map[string]*redis.Resp, name string) int64 {
+	val := getOptionalInt64FromRespMap(mp, name)
+	if val == nil {
+		return 0
+	}
+	return *val
+}
+// Returns nil if the value is missing or nil.
+func getOptionalInt64FromRespMap(mp map[string]*redis.Resp, name string) *int64 {
+	resp, hasName := mp[name]
+	if !hasName {
+		return nil
+	}
+	val, err := resp.Int64()
+	if err != nil {
+		return nil
+	}
+	return &val
+}
\ No newline at end of file
@@ -0,0 +1,37 @@
+package redis
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+
+	"github.com/bencase/revis-service/dto"
+)
+
+// Builds the tls.Config for a connection from its PEM-encoded certificates.
+// The host is used for verification when no explicit server name was given.
+func getTlsConfig(tlsConn *dto.TlsConfig, host string) (*tls.Config, error) {
+	tlsConfig := &tls.Config{
+		ServerName: tlsConn.ServerName,
+		InsecureSkipVerify: tlsConn.SkipVerify,
+	}
+	if tlsConfig.ServerName == "" {
+		tlsConfig.ServerName = host
+	}
+	if tlsConn.CaCert != "" {
+		certPool := x509.NewCertPool()
+		if !certPool.AppendCertsFromPEM([]byte(tlsConn.CaCert)) {
+			return nil, errors.New("Could not parse any certificates from the CA bundle")
+		}
+		tlsConfig.RootCAs = certPool
+	}
+	if tlsConn.ClientCert != "" || tlsConn.ClientKey != "" {
+		if tlsConn.ClientCert == "" || tlsConn.ClientKey == "" {
+			return nil, errors.New("Both a client certificate and a client key must be provided")
+		}
+		cert, err := tls.X509KeyPair([]byte(tlsConn.ClientCert), []byte(tlsConn.ClientKey))
+		if err != nil { return nil, err }
+		tlsConfig.Certificates = []tls.Certificate{cert}
+	}
+	return tlsConfig, nil
+}
\ No newline at end of file
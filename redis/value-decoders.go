@@ -0,0 +1,318 @@
+package redis
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"sync"
+	"unicode/utf8"
+
+	"github.com/bencase/revis-service/connections"
+	"github.com/bencase/revis-service/dto"
+)
+
+// Larger values aren't decoded, since every string value in a scan would be
+const maxDecodeSize = 4 * 1024 * 1024
+// Auto-detection stops after this many decoders, such as base64 then gzip then json
+const maxAutoDecodeSteps = 4
+
+var DecoderNameTakenError = errors.New("A decoder with that name is already registered")
+
+// Transforms raw values into structured data for display. Decoders can be
+// registered with RegisterDecoder, and are then available to connections'
+// decoder rules by name, and to auto-detection.
+type ValueDecoder interface {
+	Name() string
+	// Reports whether the value looks like it's in the decoder's format. It's
+	// used for auto-detection, which takes the first decoder to claim a value,
+	// so it should be cheap and rarely wrong.
+	Detect(raw []byte) bool
+	// Returns a value that can be marshalled to json, or a []byte that
+	// following decoders may decode further, as with decompressing.
+	Decode(raw []byte) (interface{}, error)
+}
+
+type decoderRegistry struct {
+	mutex sync.RWMutex
+	decoders map[string]ValueDecoder
+	// The order in which decoders are tried when auto-detecting
+	ordered []ValueDecoder
+}
+
+var decoders = newDecoderRegistry()
+
+func newDecoderRegistry() *decoderRegistry {
+	registry := &decoderRegistry{decoders: make(map[string]ValueDecoder)}
+	// The formats with the most distinctive markers go first
+	registry.add(&gzipDecoder{})
+	registry.add(&javaDecoder{})
+	registry.add(&phpDecoder{})
+	registry.add(&msgpackDecoder{})
+	registry.add(&jsonDecoder{})
+	registry.add(&base64Decoder{registry: registry})
+	return registry
+}
+
+// Makes the decoder available by name. Decoders registered later are tried
+// first when auto-detecting, so that they take precedence over the built-in ones.
+func RegisterDecoder(decoder ValueDecoder) error {
+	name := decoder.Name()
+	if name == "" || name == dto.DecoderAuto || name == dto.DecoderNone {
+		return errors.New("\"" + name + "\" can't be used as a decoder name")
+	}
+	decoders.mutex.Lock()
+	defer decoders.mutex.Unlock()
+	if _, hasName := decoders.decoders[name]; hasName {
+		return DecoderNameTakenError
+	}
+	decoders.decoders[name] = decoder
+	decoders.ordered = append([]ValueDecoder{decoder}, decoders.ordered...)
+	return nil
+}
+
+// Returns the names of the registered decoders in the order they're auto-detected.
+func GetDecoderNames() []string {
+	decoders.mutex.RLock()
+	defer decoders.mutex.RUnlock()
+	names := make([]string, len(decoders.ordered))
+	for i, decoder := range decoders.ordered {
+		names[i] = decoder.Name()
+	}
+	return names
+}
+
+// Only used while setting up the registry, before it's shared.
+func (this *decoderRegistry) add(decoder ValueDecoder) {
+	this.decoders[decoder.Name()] = decoder
+	this.ordered = append(this.ordered, decoder)
+}
+
+func (this *decoderRegistry) get(name string) ValueDecoder {
+	this.mutex.RLock()
+	defer this.mutex.RUnlock()
+	return this.decoders[name]
+}
+
+func (this *decoderRegistry) getOrdered() []ValueDecoder {
+	this.mutex.RLock()
+	defer this.mutex.RUnlock()
+	return this.ordered
+}
+
+// Runs the value through the named decoders in turn. Returns the decoded
+// value and the decoders that were used, which are none if auto-detection
+// didn't recognize the value.
+func (this *decoderRegistry) decode(raw []byte, decoderNames []string) (interface{}, []string,
+		error) {
+	var val interface{} = raw
+	var used []string
+	for _, name := range decoderNames {
+		bytes, isBytes := val.([]byte)
+		if !isBytes {
+			return nil, used, errors.New("There's nothing left to decode with " + name)
+		}
+		switch name {
+		case dto.DecoderNone : return nil, nil, nil
+		case dto.DecoderAuto :
+			var autoUsed []string
+			val, autoUsed = this.autoDecode(bytes)
+			used = append(used, autoUsed...)
+		default :
+			decoder := this.get(name)
+			if decoder == nil {
+				return nil, used, errors.New("There's no decoder named " + name)
+			}
+			var err error
+			val, err = decoder.Decode(bytes)
+			if err != nil {
+				return nil, used, errors.New(name + ": " + err.Error())
+			}
+			used = append(used, name)
+		}
+	}
+	return val, used, nil
+}
+
+// Decodes with the first decoder to detect the value, for as long as that
+// leaves bytes that another decoder detects.
+func (this *decoderRegistry) autoDecode(raw []byte) (interface{}, []string) {
+	var val interface{} = raw
+	var used []string
+	for step := 0; step < maxAutoDecodeSteps; step++ {
+		bytes, isBytes := val.([]byte)
+		if !isBytes {
+			break
+		}
+		decoded, name := this.detectAndDecode(bytes)
+		if name == "" {
+			break
+		}
+		val = decoded
+		used = append(used, name)
+	}
+	return val, used
+}
+
+// Returns blank for the name if no decoder could decode the value.
+func (this *decoderRegistry) detectAndDecode(raw []byte) (interface{}, string) {
+	for _, decoder := range this.getOrdered() {
+		if !decoder.Detect(raw) {
+			continue
+		}
+		// Detection may be fooled, in which case another decoder can try
+		val, err := decoder.Decode(raw)
+		if err == nil {
+			return val, decoder.Name()
+		}
+	}
+	return nil, ""
+}
+
+// Decodes the string values of the keys by the first of the connection's
+// rules that matches each key, or by auto-detection if none does. It must be
+// done before the keys are encoded, while their values are still raw.
+func decodeValues(keys []*dto.Key, rules []*dto.DecoderRule) {
+	for _, key := range keys {
+		str, isStr := key.Val.(string)
+		// Strings are left without a type
+		if !isStr || key.Type != "" {
+			continue
+		}
+		decoderNames := getDecodersForKey(key.Key, rules)
+		if len(str) > maxDecodeSize {
+			// Only worth reporting if the value was meant to be decoded
+			if len(decoderNames) > 0 && decoderNames[0] != dto.DecoderAuto &&
+					decoderNames[0] != dto.DecoderNone {
+				key.DecodeError = "The value is too large to decode"
+			}
+			continue
+		}
+		decodeValue(key, []byte(str), decoderNames)
+	}
+}
+
+func decodeValue(key *dto.Key, raw []byte, decoderNames []string) {
+	val, used, err := decoders.decode(raw, decoderNames)
+	if err != nil {
+		key.DecodeError = err.Error()
+		return
+	}
+	if len(used) == 0 {
+		return
+	}
+	if bytes, isBytes := val.([]byte); isBytes {
+		if utf8.Valid(bytes) {
+			val = string(bytes)
+		} else {
+			val = base64.StdEncoding.EncodeToString(bytes)
+			key.DecodedEncoding = dto.EncodingBase64
+		}
+	}
+	// A value that can't be marshalled would fail the whole response
+	_, err = json.Marshal(val)
+	if err != nil {
+		key.DecodedEncoding = ""
+		key.DecodeError = "The decoded value can't be shown: " + err.Error()
+		return
+	}
+	key.Decoded = val
+	key.DecodedWith = used
+}
+
+// The rules are read from the connection each time, since the register keeps
+// its runners across changes to their connections.
+func getDecoderRules(connName string) ([]*dto.DecoderRule, error) {
+	conn, err := connections.GetConnectionWithName(connName)
+	if err != nil { return nil, err }
+	if conn == nil {
+		return nil, nil
+	}
+	return conn.Decoders, nil
+}
+
+func getDecodersForKey(keyName string, rules []*dto.DecoderRule) []string {
+	for _, rule := range rules {
+		if rule.Pattern == "" || matchGlob(rule.Pattern, keyName) {
+			return rule.Decoders
+		}
+	}
+	return []string{dto.DecoderAuto}
+}
+
+// Matches a key against a pattern the way Redis does for SCAN's MATCH. Only
+// the last star is backtracked to, which is enough since a later star can
+// match anything an earlier one could have, so patterns with many stars can't
+// make the matching exponential.
+func matchGlob(pattern string, str string) bool {
+	p, s := 0, 0
+	// Where to resume after the last star, once it's taken one more byte
+	starP, starS := -1, 0
+	for s < len(str) {
+		if p < len(pattern) {
+			switch pattern[p] {
+			case '*' :
+				starP, starS = p, s
+				p++
+				continue
+			case '?' :
+				p, s = p + 1, s + 1
+				continue
+			case '[' :
+				matched, rest := matchGlobClass(pattern[p + 1:], str[s])
+				if matched {
+					p, s = len(pattern) - len(rest), s + 1
+					continue
+				}
+			default :
+				literal, next := pattern[p], p + 1
+				if literal == '\\' && next < len(pattern) {
+					literal, next = pattern[next], next + 1
+				}
+				if literal == str[s] {
+					p, s = next, s + 1
+					continue
+				}
+			}
+		}
+		if starP < 0 {
+			return false
+		}
+		starS++
+		p, s = starP + 1, starS
+	}
+	for p < len(pattern) && pattern[p] == '*' {
+		p++
+	}
+	return p == len(pattern)
+}
+
+// Matches a byte against a class such as "a-z]" or "^abc]", whose opening
+// bracket has been removed. Returns the rest of the pattern after the class.
+func matchGlobClass(class string, b byte) (bool, string) {
+	negate := len(class) > 0 && class[0] == '^'
+	if negate {
+		class = class[1:]
+	}
+	matched := false
+	for len(class) > 0 && class[0] != ']' {
+		switch {
+		case class[0] == '\\' && len(class) > 1 :
+			matched = matched || class[1] == b
+			class = class[2:]
+		case len(class) > 2 && class[1] == '-' && class[2] != ']' :
+			low, high := class[0], class[2]
+			if low > high {
+				low, high = high, low
+			}
+			matched = matched || (b >= low && b <= high)
+			class = class[3:]
+		default :
+			matched = matched || class[0] == b
+			class = class[1:]
+		}
+	}
+	if len(class) > 0 {
+		class = class[1:]
+	}
+	return matched != negate, class
+}
\ No newline at end of file
@@ -0,0 +1,236 @@
+package redis
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/base64"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/bencase/revis-service/dto"
+)
+
+func TestMatchGlob(t *testing.T) {
+	tests := []struct {
+		pattern string
+		str string
+		want bool
+	}{
+		{"", "", true},
+		{"", "a", false},
+		{"*", "", true},
+		{"*", "anything", true},
+		{"user:*", "user:1", true},
+		{"user:*", "session:1", false},
+		{"*:1", "user:1", true},
+		{"a**b", "ab", true},
+		{"a*b*c", "axxbyyc", true},
+		{"a*b*c", "axxbyy", false},
+		{"?", "a", true},
+		{"?", "", false},
+		{"a?c", "abc", true},
+		{"a?c", "ac", false},
+		{"[abc]", "b", true},
+		{"[abc]", "d", false},
+		{"[a-c]", "b", true},
+		{"[c-a]", "b", true},
+		{"[a-c]", "-", false},
+		{"[^a-c]", "d", true},
+		{"[^a-c]", "a", false},
+		{"[a-]", "-", true},
+		{"[\\]]", "]", true},
+		{"[\\-]", "-", true},
+		{"[abc", "a", true},
+		{"[abc", "", false},
+		{"x[", "x", false},
+		{"\\*", "*", true},
+		{"\\*", "a", false},
+		{"\\?", "?", true},
+		{"\\[a]", "[a]", true},
+		{"a\\", "a\\", true},
+		{"*[0-9]", "key9", true},
+		{"*[0-9]", "key", false},
+		{"*a*a*a*a*b", strings.Repeat("a", 30) + "b", true},
+		{"*a*a*a*a*b", strings.Repeat("a", 30), false},
+	}
+	for _, test := range tests {
+		if got := matchGlob(test.pattern, test.str); got != test.want {
+			t.Errorf("matchGlob(%q, %q) = %v, want %v", test.pattern, test.str, got, test.want)
+		}
+	}
+}
+
+func TestMatchGlobPathologicalPatterns(t *testing.T) {
+	tests := []struct {
+		pattern string
+		str string
+	}{
+		{"*a*a*a*a*a*a*a*a*a*a*b", strings.Repeat("a", 1000)},
+		{strings.Repeat("*a", 100) + "*b", strings.Repeat("a", 10000)},
+		{strings.Repeat("?*", 50) + "b", strings.Repeat("a", 10000)},
+	}
+	for _, test := range tests {
+		start := time.Now()
+		if matchGlob(test.pattern, test.str) {
+			t.Errorf("matchGlob(%q, ...) matched", test.pattern)
+		}
+		if elapsed := time.Since(start); elapsed > time.Second {
+			t.Errorf("matchGlob(%q, ...) took %v", test.pattern, elapsed)
+		}
+	}
+}
+
+func TestMatchGlobLongKeys(t *testing.T) {
+	long := strings.Repeat("a", 1000000)
+	tests := []struct {
+		pattern string
+		str string
+		want bool
+	}{
+		{"*b", long + "b", true},
+		{"*b", long, false},
+		{"*a*a*a*a*b", long + "b", true},
+		{"a*?b", long + "xb", true},
+		{"*[0-9]", long + "7", true},
+	}
+	for _, test := range tests {
+		if got := matchGlob(test.pattern, test.str); got != test.want {
+			t.Errorf("matchGlob(%q, <%d bytes>) = %v, want %v", test.pattern, len(test.str), got,
+				test.want)
+		}
+	}
+}
+
+func TestBase64DetectionFalsePositives(t *testing.T) {
+	tests := []struct {
+		raw string
+		want bool
+	}{
+		// Plain values that are valid base64 but decode to nothing recognizable
+		{"abcdefgh", false},
+		{"password", false},
+		{"username", false},
+		{"Test1234", false},
+		{"abcdefghijkl", false},
+		{"Zm9vYmFy", false},
+		{"MTIzNDU2Nzg=", false},
+		// Too short to be trusted, even though it decodes to "{}"
+		{"e30=", false},
+		{"not base64!", false},
+		{"abcdefg", false},
+		{base64.StdEncoding.EncodeToString([]byte(`{"a":1}`)), true},
+		{base64.StdEncoding.EncodeToString([]byte(`a:1:{i:0;s:1:"a";}`)), true},
+		{base64.StdEncoding.EncodeToString(gzipBytes(t, []byte("hello"))), true},
+	}
+	decoder := decoders.get(dto.DecoderBase64)
+	for _, test := range tests {
+		if got := decoder.Detect([]byte(test.raw)); got != test.want {
+			t.Errorf("Detect(%q) = %v, want %v", test.raw, got, test.want)
+		}
+	}
+}
+
+func TestDecodeValues(t *testing.T) {
+	encodedJson := base64.StdEncoding.EncodeToString(gzipBytes(t, []byte(`{"a":[1,2]}`)))
+	tests := []struct {
+		name string
+		key *dto.Key
+		rules []*dto.DecoderRule
+		wantDecoded interface{}
+		wantWith []string
+		wantEncoding string
+		wantError bool
+	}{
+		{
+			name: "plain string",
+			key: &dto.Key{Key: "k", Val: "hello"},
+		},
+		{
+			name: "auto-detected chain",
+			key: &dto.Key{Key: "k", Val: encodedJson},
+			wantDecoded: map[string]interface{}{"a": []interface{}{jsonNumber("1"), jsonNumber("2")}},
+			wantWith: []string{dto.DecoderBase64, dto.DecoderGzip, dto.DecoderJson},
+		},
+		{
+			name: "non-string types are skipped",
+			key: &dto.Key{Key: "k", Type: "hash", Val: "[1]"},
+		},
+		{
+			name: "rule for another pattern",
+			key: &dto.Key{Key: "session:1", Val: "[1]"},
+			rules: []*dto.DecoderRule{{Pattern: "user:*", Decoders: []string{dto.DecoderNone}}},
+			wantDecoded: []interface{}{jsonNumber("1")},
+			wantWith: []string{dto.DecoderJson},
+		},
+		{
+			name: "rule turning decoding off",
+			key: &dto.Key{Key: "user:1", Val: "[1]"},
+			rules: []*dto.DecoderRule{{Pattern: "user:*", Decoders: []string{dto.DecoderNone}}},
+		},
+		{
+			name: "rule with a failing decoder",
+			key: &dto.Key{Key: "user:1", Val: "{"},
+			rules: []*dto.DecoderRule{{Pattern: "user:*", Decoders: []string{dto.DecoderJson}}},
+			wantError: true,
+		},
+		{
+			name: "rule with an unknown decoder",
+			key: &dto.Key{Key: "user:1", Val: "[1]"},
+			rules: []*dto.DecoderRule{{Decoders: []string{"unknown"}}},
+			wantError: true,
+		},
+		{
+			name: "rule decoding past a structured value",
+			key: &dto.Key{Key: "user:1", Val: "[1]"},
+			rules: []*dto.DecoderRule{{Decoders: []string{dto.DecoderJson, dto.DecoderJson}}},
+			wantError: true,
+		},
+		{
+			name: "binary result",
+			key: &dto.Key{Key: "k", Val: "/w=="},
+			rules: []*dto.DecoderRule{{Decoders: []string{dto.DecoderBase64}}},
+			wantDecoded: "/w==",
+			wantWith: []string{dto.DecoderBase64},
+			wantEncoding: dto.EncodingBase64,
+		},
+		{
+			name: "too large for a rule",
+			key: &dto.Key{Key: "k", Val: strings.Repeat("a", maxDecodeSize + 1)},
+			rules: []*dto.DecoderRule{{Decoders: []string{dto.DecoderJson}}},
+			wantError: true,
+		},
+		{
+			name: "too large to auto-detect",
+			key: &dto.Key{Key: "k", Val: "[" + strings.Repeat(" ", maxDecodeSize) + "]"},
+		},
+	}
+	for _, test := range tests {
+		decodeValues([]*dto.Key{test.key}, test.rules)
+		if !reflect.DeepEqual(test.key.Decoded, test.wantDecoded) {
+			t.Errorf("%s: decoded %#v, want %#v", test.name, test.key.Decoded, test.wantDecoded)
+		}
+		if !reflect.DeepEqual(test.key.DecodedWith, test.wantWith) {
+			t.Errorf("%s: decoded with %v, want %v", test.name, test.key.DecodedWith, test.wantWith)
+		}
+		if test.key.DecodedEncoding != test.wantEncoding {
+			t.Errorf("%s: encoding %q, want %q", test.name, test.key.DecodedEncoding, test.wantEncoding)
+		}
+		if (test.key.DecodeError != "") != test.wantError {
+			t.Errorf("%s: decode error %q", test.name, test.key.DecodeError)
+		}
+	}
+}
+
+func gzipBytes(t *testing.T, raw []byte) []byte {
+	var buf bytes.Buffer
+	writer := gzip.NewWriter(&buf)
+	if _, err := writer.Write(raw); err != nil {
+		t.Fatal(err)
+	}
+	if err := writer.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
\ No newline at end of file
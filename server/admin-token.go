@@ -0,0 +1,46 @@
+package server
+
+import (
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/hex"
+	"errors"
+	"io"
+	"net/http"
+	"strings"
+
+	"github.com/bencase/revis-service/config"
+	"github.com/bencase/revis-service/util"
+)
+
+const AuthorizationHeader string = "Authorization"
+const bearerPrefix = "Bearer "
+
+const UnauthorizedCode string = "UNAUTHORIZED"
+
+var UnauthorizedError = errors.New("A valid admin token is required for this request")
+
+// Generates the token that administrative endpoints require, and writes it to
+// a file in the data directory that only the current user can read. A new
+// token is made every time the server starts.
+func newAdminToken() (string, error) {
+	tokenBytes := make([]byte, 32)
+	_, err := io.ReadFull(rand.Reader, tokenBytes)
+	if err != nil { return "", err }
+	token := hex.EncodeToString(tokenBytes)
+	err = util.WriteFileAtomic(config.DataPath + config.AdminTokenFilename, []byte(token), 0600)
+	if err != nil { return "", err }
+	return token, nil
+}
+
+func (this *RedisServer) checkAdminToken(r *http.Request) error {
+	authHeader := r.Header.Get(AuthorizationHeader)
+	if !strings.HasPrefix(authHeader, bearerPrefix) {
+		return UnauthorizedError
+	}
+	token := strings.TrimPrefix(authHeader, bearerPrefix)
+	if subtle.ConstantTimeCompare([]byte(token), []byte(this.adminToken)) != 1 {
+		return UnauthorizedError
+	}
+	return nil
+}
\ No newline at end of file
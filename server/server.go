@@ -4,9 +4,12 @@ import (
 	"encoding/json"
 	"errors"
 	"fmt"
+	"io"
 	"net/http"
 	"strconv"
+	"strings"
 	
+	"github.com/gorilla/mux"
 	glogging "github.com/op/go-logging"
 	
 	rconns "github.com/bencase/revis-service/connections"
@@ -18,18 +21,63 @@ const ExposeHeadersHeader string = "Access-Control-Expose-Headers"
 const ConnNameHeader string = "connname"
 const PatternHeader string = "pattern"
 const ScanIdHeader string = "scanid"
+const ETagHeader string = "ETag"
+const IfMatchHeader string = "If-Match"
+const ConfirmTokenHeader string = "confirmtoken"
+// A single key, which is given as a header since keys can hold any characters
+const KeyHeader string = "key"
+// How the key and pattern headers are encoded if they hold binary data
+const KeyEncodingHeader string = "keyencoding"
+
+const RedactPasswordParam string = "redactPassword"
+// Filters and ordering for the connections list. The tag parameter can be repeated.
+const FolderParam string = "folder"
+const TagParam string = "tag"
+const EnvironmentParam string = "environment"
+const SortParam string = "sort"
+// Page through a collection's members, or other lists
+const CursorParam string = "cursor"
+const CountParam string = "count"
+const MatchParam string = "match"
+// Makes a pattern delete only report what it would delete
+const DryRunParam string = "dryRun"
+
+// Makes a pattern delete save the keys to a backup first
+const BackupParam string = "backup"
+// Narrow a pattern delete to some of the keys matching the pattern. TTLs and
+// idle times are in seconds.
+const TypeParam string = "type"
+const TtlParam string = "ttl"
+const MinTtlParam string = "minTtl"
+const MaxTtlParam string = "maxTtl"
+const MinIdleParam string = "minIdle"
+
+// The path variables holding a job's or backup's ID
+const JobIdVar string = "id"
+const BackupIdVar string = "id"
+
+// Codes in error responses for failures the UI handles specially
+const StoreLockedCode string = "STORELOCKED"
+const WrongMasterPasswordCode string = "WRONGMASTERPASSWORD"
+const StaleRevisionCode string = "STALEREVISION"
+const InvalidConnectionCode string = "INVALIDCONNECTION"
+const ReadOnlyCode string = "READONLY"
+const ConfirmationRequiredCode string = "CONFIRMATIONREQUIRED"
 
 var logger = glogging.MustGetLogger("server")
 
 
 type RedisServer struct {
 	redisService *redis.RedisService
+	adminToken string
 }
 
 
 func NewRedisServer() (*RedisServer, error) {
+	adminToken, err := newAdminToken()
+	if err != nil { return nil, err }
 	redisService := redis.NewRedisService()
-	return &RedisServer{redisService: redisService}, nil
+	return &RedisServer{redisService: redisService, adminToken: adminToken}, nil
 }
 
 
@@ -37,13 +85,27 @@ func (this *RedisServer) GetConnections(w http.ResponseWriter, r *http.Request)
 	defer recoverFromPanic(w, "GetConnections")
 	w.Header().Add("Content-Type", "application/json")
 	
-	conns, err := rconns.ReadConnections()
+	conns, revision, err := rconns.ReadConnectionsWithRevision()
 	if err != nil {
 		processError(w, "Error reading connections:", err)
 		return
 	}
 	
-	connsResp := &dto.ConnectionsResponse{Connections: conns}
+	query := r.URL.Query()
+	conns = rconns.FilterConnections(conns, &rconns.ConnectionFilter{
+		Folder: query.Get(FolderParam),
+		Tags: query[TagParam],
+		Environment: query.Get(EnvironmentParam)})
+	err = rconns.SortConnections(conns, query.Get(SortParam))
+	if err != nil {
+		processError(w, "Error sorting connections:", err)
+		return
+	}
+	
+	setRevisionHeader(w, revision)
+	connsResp := &dto.ConnectionsResponse{Connections: conns,
+		Locked: rconns.IsStoreLocked(),
+		Revision: revision}
 	respBytes, err := connsResp.JsonBytes()
 	if err != nil {
 		processError(w, "Error marshalling connections list to json:", err)
@@ -54,6 +116,173 @@ func (this *RedisServer) GetConnections(w http.ResponseWriter, r *http.Request)
 }
 
 
+func (this *RedisServer) GetLockStatus(w http.ResponseWriter, r *http.Request) {
+	defer recoverFromPanic(w, "GetLockStatus")
+	w.Header().Add("Content-Type", "application/json")
+	
+	statusResp := &dto.LockStatusResponse{
+		MasterPasswordEnabled: rconns.MasterPasswordEnabled(),
+		Locked: rconns.IsStoreLocked()}
+	respBytes, err := statusResp.JsonBytes()
+	if err != nil {
+		processError(w, "Error marshalling lock status to json:", err)
+		return
+	}
+	
+	w.Write(respBytes)
+}
+
+
+func (this *RedisServer) UnlockConnections(w http.ResponseWriter,
+		r *http.Request) {
+	defer recoverFromPanic(w, "UnlockConnections")
+	w.Header().Add("Content-Type", "application/json")
+	
+	reader := r.Body
+	reqObj := new(dto.MasterPasswordRequest)
+	err := json.NewDecoder(reader).Decode(reqObj)
+	if err != nil {
+		processError(w, "Error decoding json:", err)
+		return
+	}
+	
+	err = rconns.UnlockStore(reqObj.Password)
+	if err != nil {
+		processError(w, "Error unlocking connections:", err)
+		return
+	}
+	
+	returnBaseResponse(w)
+}
+
+
+func (this *RedisServer) LockConnections(w http.ResponseWriter,
+		r *http.Request) {
+	defer recoverFromPanic(w, "LockConnections")
+	w.Header().Add("Content-Type", "application/json")
+	
+	err := rconns.LockStore()
+	if err != nil {
+		processError(w, "Error locking connections:", err)
+		return
+	}
+	// Open pools were created with the now-locked credentials
+	this.redisService.CloseAllConnections()
+	
+	returnBaseResponse(w)
+}
+
+
+// Requires the admin token, as well as the current password when changing
+// it, since anyone able to reach the port could otherwise replace it while
+// the store is unlocked.
+func (this *RedisServer) SetMasterPassword(w http.ResponseWriter,
+		r *http.Request) {
+	defer recoverFromPanic(w, "SetMasterPassword")
+	w.Header().Add("Content-Type", "application/json")
+	
+	err := this.checkAdminToken(r)
+	if err != nil {
+		processError(w, "Error authorizing master password change:", err)
+		return
+	}
+	
+	reader := r.Body
+	reqObj := new(dto.MasterPasswordRequest)
+	err = json.NewDecoder(reader).Decode(reqObj)
+	if err != nil {
+		processError(w, "Error decoding json:", err)
+		return
+	}
+	
+	err = rconns.SetMasterPassword(reqObj.CurrentPassword, reqObj.Password)
+	if err != nil {
+		processError(w, "Error setting master password:", err)
+		return
+	}
+	
+	returnBaseResponse(w)
+}
+
+
+func (this *RedisServer) GetConnectionsVersions(w http.ResponseWriter,
+		r *http.Request) {
+	defer recoverFromPanic(w, "GetConnectionsVersions")
+	w.Header().Add("Content-Type", "application/json")
+	
+	versions, err := rconns.GetConnectionsVersions()
+	if err != nil {
+		processError(w, "Error reading connections versions:", err)
+		return
+	}
+	
+	respObj := &dto.ConnectionsVersionsResponse{Versions: versions}
+	respBytes, err := respObj.JsonBytes()
+	if err != nil {
+		processError(w, "Error marshalling connections versions to json:", err)
+		return
+	}
+	
+	w.Write(respBytes)
+}
+
+
+func (this *RedisServer) RestoreConnectionsVersion(w http.ResponseWriter,
+		r *http.Request) {
+	defer recoverFromPanic(w, "RestoreConnectionsVersion")
+	w.Header().Add("Content-Type", "application/json")
+	
+	reader := r.Body
+	reqObj := new(dto.RestoreConnectionsVersionRequest)
+	err := json.NewDecoder(reader).Decode(reqObj)
+	if err != nil {
+		processError(w, "Error decoding json:", err)
+		return
+	}
+	
+	err = rconns.RestoreConnectionsVersion(reqObj.Id)
+	if err != nil {
+		processError(w, "Error restoring connections version:", err)
+		return
+	}
+	// Open pools may belong to connections that were changed or removed
+	this.redisService.CloseAllConnections()
+	
+	returnBaseResponse(w)
+}
+
+
+// Requires the admin token, since anyone able to reach the port could
+// otherwise replace the key.
+func (this *RedisServer) RotateKey(w http.ResponseWriter,
+		r *http.Request) {
+	defer recoverFromPanic(w, "RotateKey")
+	w.Header().Add("Content-Type", "application/json")
+	
+	err := this.checkAdminToken(r)
+	if err != nil {
+		processError(w, "Error authorizing key rotation:", err)
+		return
+	}
+	
+	reader := r.Body
+	reqObj := new(dto.RotateKeyRequest)
+	err = json.NewDecoder(reader).Decode(reqObj)
+	if err != nil && err != io.EOF {
+		processError(w, "Error decoding json:", err)
+		return
+	}
+	
+	err = rconns.RotateKey(reqObj.MasterPassword)
+	if err != nil {
+		processError(w, "Error rotating encryption key:", err)
+		return
+	}
+	
+	returnBaseResponse(w)
+}
+
+
 func (this *RedisServer) UpsertConnections(w http.ResponseWriter,
 		r *http.Request) {
 	defer recoverFromPanic(w, "UpsertConnections")
@@ -67,12 +296,17 @@ func (this *RedisServer) UpsertConnections(w http.ResponseWriter,
 		return
 	}
 	
-	err = rconns.UpsertConnections(reqObj)
+	revision, err := rconns.UpsertConnections(reqObj, getExpectedRevision(r))
+	if err == rconns.RevisionMismatchError {
+		respondWithCurrentConnections(w, "Rejected stale connections upsert:", err)
+		return
+	}
 	if err != nil {
 		processError(w, "Error upserting connections:", err)
 		return
 	}
 	
+	setRevisionHeader(w, revision)
 	returnBaseResponse(w)
 }
 
@@ -90,16 +324,107 @@ func (this *RedisServer) DeleteConnections(w http.ResponseWriter,
 		return
 	}
 	
-	err = rconns.DeleteConnections(reqObj.ConnectionNames)
+	revision, err := rconns.DeleteConnections(reqObj.ConnectionNames, getExpectedRevision(r))
+	if err == rconns.RevisionMismatchError {
+		respondWithCurrentConnections(w, "Rejected stale connections delete:", err)
+		return
+	}
 	if err != nil {
 		processError(w, "Error upserting connections:", err)
 		return
 	}
 	
+	setRevisionHeader(w, revision)
 	returnBaseResponse(w)
 }
 
 
+func (this *RedisServer) ImportConnectionUri(w http.ResponseWriter,
+		r *http.Request) {
+	defer recoverFromPanic(w, "ImportConnectionUri")
+	w.Header().Add("Content-Type", "application/json")
+	
+	reader := r.Body
+	reqObj := new(dto.ImportConnectionUriRequest)
+	err := json.NewDecoder(reader).Decode(reqObj)
+	if err != nil {
+		processError(w, "Error decoding json:", err)
+		return
+	}
+	
+	conn, err := rconns.ParseConnectionUri(reqObj.Uri)
+	if err != nil {
+		processError(w, "Error parsing connection URI:", err)
+		return
+	}
+	if reqObj.Name != "" {
+		conn.Name = reqObj.Name
+	}
+	
+	upsertReq := &dto.UpsertConnectionsRequest{
+		Connections: []*dto.ConnUpsert{&dto.ConnUpsert{NewConn: conn}}}
+	revision, err := rconns.UpsertConnections(upsertReq, getExpectedRevision(r))
+	if err == rconns.RevisionMismatchError {
+		respondWithCurrentConnections(w, "Rejected stale connection import:", err)
+		return
+	}
+	if err != nil {
+		processError(w, "Error upserting connections:", err)
+		return
+	}
+	
+	setRevisionHeader(w, revision)
+	connsResp := &dto.ConnectionsResponse{Connections: []*dto.Connection{conn}}
+	respBytes, err := connsResp.JsonBytes()
+	if err != nil {
+		processError(w, "Error marshalling connections list to json:", err)
+		return
+	}
+	
+	w.Write(respBytes)
+}
+
+
+func (this *RedisServer) ExportConnectionUri(w http.ResponseWriter,
+		r *http.Request) {
+	defer recoverFromPanic(w, "ExportConnectionUri")
+	w.Header().Add("Content-Type", "application/json")
+
+	connName := r.Header.Get(ConnNameHeader)
+	if connName == "" {
+		processError(w, "Error parsing header:",
+			errors.New("Header does not contain connection name"))
+		return
+	}
+	redactPassword := r.URL.Query().Get(RedactPasswordParam) == "true"
+	
+	conn, err := rconns.GetConnectionWithName(connName)
+	if err != nil {
+		processError(w, "Error reading connections:", err)
+		return
+	}
+	if conn == nil {
+		processError(w, "Error exporting connection:", rconns.ConnectionNotFoundError)
+		return
+	}
+	
+	uri, err := rconns.GetUriOfConn(conn, redactPassword)
+	if err != nil {
+		processError(w, "Error exporting connection:", err)
+		return
+	}
+	
+	uriResp := &dto.ConnectionUriResponse{Uri: uri}
+	respBytes, err := uriResp.JsonBytes()
+	if err != nil {
+		processError(w, "Error marshalling connection URI to json:", err)
+		return
+	}
+	
+	w.Write(respBytes)
+}
+
+
 func (this *RedisServer) TestConnection(w http.ResponseWriter,
 		r *http.Request) {
 	defer recoverFromPanic(w, "TestConnection")
@@ -113,13 +438,20 @@ func (this *RedisServer) TestConnection(w http.ResponseWriter,
 		return
 	}
 
-	err = redis.TestConn(conn)
+	node, err := redis.TestConn(conn)
 	if err != nil {
 		processError(w, "Connection error:", err)
 		return
 	}
 	
-	returnBaseResponse(w)
+	testResp := &dto.TestConnectionResponse{Node: node}
+	respBytes, err := testResp.JsonBytes()
+	if err != nil {
+		processError(w, "Error marshalling connection test response to json:", err)
+		return
+	}
+
+	w.Write(respBytes)
 }
 
 
@@ -147,7 +479,11 @@ func (this *RedisServer) startGettingKeysWithValues(w http.ResponseWriter,
 			errors.New("Header does not contain connection name"))
 		return
 	}
-	pattern := r.Header.Get(PatternHeader)
+	pattern, err := getDecodedHeader(r, PatternHeader)
+	if err != nil {
+		processError(w, "Error parsing header:", err)
+		return
+	}
 
 	keys, scanId, hasMoreKeys, err := this.redisService.
 		StartGettingKeysWithValues(connName, pattern)
@@ -198,6 +534,66 @@ func respondWithKeys(w http.ResponseWriter, keys []*dto.Key, scanId int, hasMore
 }
 
 
+func (this *RedisServer) GetCollectionPage(w http.ResponseWriter,
+		r *http.Request) {
+	defer recoverFromPanic(w, "GetCollectionPage")
+	w.Header().Add("Content-Type", "application/json")
+
+	connName := r.Header.Get(ConnNameHeader)
+	if connName == "" {
+		processError(w, "Error parsing header:",
+			errors.New("Header does not contain connection name"))
+		return
+	}
+	key, err := getDecodedHeader(r, KeyHeader)
+	if err != nil {
+		processError(w, "Error parsing header:", err)
+		return
+	}
+	query := r.URL.Query()
+	count := 0
+	if countStr := query.Get(CountParam); countStr != "" {
+		count, err = strconv.Atoi(countStr)
+		if err != nil {
+			processError(w, "Error parsing count:", err)
+			return
+		}
+	}
+
+	page, err := this.redisService.GetCollectionPage(connName, key, query.Get(CursorParam),
+		count, query.Get(MatchParam))
+	if err != nil {
+		processError(w, "Error getting collection members:", err)
+		return
+	}
+
+	pageResp := &dto.CollectionPageResponse{CollectionPage: *page}
+	respBytes, err := pageResp.JsonBytes()
+	if err != nil {
+		processError(w, "Error marshalling collection members to json:", err)
+		return
+	}
+
+	w.Write(respBytes)
+}
+
+// Lists the decoders that connections' decoder rules can name.
+func (this *RedisServer) GetDecoders(w http.ResponseWriter,
+		r *http.Request) {
+	defer recoverFromPanic(w, "GetDecoders")
+	w.Header().Add("Content-Type", "application/json")
+
+	decodersResp := &dto.DecodersResponse{Decoders: redis.GetDecoderNames()}
+	respBytes, err := decodersResp.JsonBytes()
+	if err != nil {
+		processError(w, "Error marshalling decoders to json:", err)
+		return
+	}
+
+	w.Write(respBytes)
+}
+
+
 func (this *RedisServer) DeleteKeysMatchingPattern(w http.ResponseWriter,
 		r *http.Request) {
 	defer recoverFromPanic(w, "DeleteKeysMatchingPattern")
@@ -209,22 +605,225 @@ func (this *RedisServer) DeleteKeysMatchingPattern(w http.ResponseWriter,
 			errors.New("Header does not contain connection name"))
 		return
 	}
-	pattern := r.Header.Get(PatternHeader)
+	pattern, err := getDecodedHeader(r, PatternHeader)
+	if err != nil {
+		processError(w, "Error parsing header:", err)
+		return
+	}
+	filter, err := getKeyFilter(r)
+	if err != nil {
+		processError(w, "Error parsing key filter:", err)
+		return
+	}
+
+	if r.URL.Query().Get(DryRunParam) == "true" {
+		this.previewDeleteKeysMatchingPattern(w, connName, pattern, filter)
+		return
+	}
+	confirmToken := r.Header.Get(ConfirmTokenHeader)
 
-	deletedAllKeys, count, err := this.redisService.DeleteKeysMatchingPattern(connName, pattern)
+	backupFirst := r.URL.Query().Get(BackupParam) == "true"
+
+	job, err := this.redisService.StartDeleteJob(connName, pattern, filter, confirmToken,
+		backupFirst)
 	if err != nil {
 		processError(w, fmt.Sprintf("Error deleting keys matching pattern %[1]v: ",
 			pattern), err)
 		return
 	}
 	
-	delResp := &dto.DeleteResponse{DeletedAllKeys: deletedAllKeys, Count: count}
-	respBytes, err := delResp.JsonBytes()
+	// The delete goes on in the background, and the job can be polled for progress
+	respondWithDeleteJob(w, job, 202)
+}
+func (this *RedisServer) previewDeleteKeysMatchingPattern(w http.ResponseWriter,
+		connName string, pattern string, filter *dto.KeyFilter) {
+	preview, err := this.redisService.PreviewDeleteKeysMatchingPattern(connName, pattern, filter)
 	if err != nil {
-		processError(w, "Error marshalling delete response to json:", err)
+		processError(w, fmt.Sprintf("Error previewing delete of keys matching pattern %[1]v: ",
+			pattern), err)
 		return
 	}
+	
+	previewResp := &dto.DeletePreviewResponse{DeletePreview: *preview}
+	respBytes, err := previewResp.JsonBytes()
+	if err != nil {
+		processError(w, "Error marshalling delete preview to json:", err)
+		return
+	}
+
+	w.Write(respBytes)
+}
+
 
+func (this *RedisServer) GetDeleteJobs(w http.ResponseWriter,
+		r *http.Request) {
+	defer recoverFromPanic(w, "GetDeleteJobs")
+	w.Header().Add("Content-Type", "application/json")
+	
+	jobsResp := &dto.DeleteJobsResponse{Jobs: this.redisService.GetDeleteJobs()}
+	respBytes, err := jobsResp.JsonBytes()
+	if err != nil {
+		processError(w, "Error marshalling delete jobs to json:", err)
+		return
+	}
+	
+	w.Write(respBytes)
+}
+
+
+func (this *RedisServer) GetDeleteJob(w http.ResponseWriter,
+		r *http.Request) {
+	defer recoverFromPanic(w, "GetDeleteJob")
+	w.Header().Add("Content-Type", "application/json")
+	
+	id, err := getJobIdFromPath(r)
+	if err != nil {
+		processError(w, "Error parsing job ID:", err)
+		return
+	}
+	job, err := this.redisService.GetDeleteJob(id)
+	if err != nil {
+		processError(w, "Error getting delete job:", err)
+		return
+	}
+	
+	respondWithDeleteJob(w, job, 200)
+}
+
+
+func (this *RedisServer) CancelDeleteJob(w http.ResponseWriter,
+		r *http.Request) {
+	defer recoverFromPanic(w, "CancelDeleteJob")
+	w.Header().Add("Content-Type", "application/json")
+	
+	id, err := getJobIdFromPath(r)
+	if err != nil {
+		processError(w, "Error parsing job ID:", err)
+		return
+	}
+	job, err := this.redisService.CancelDeleteJob(id)
+	if err != nil {
+		processError(w, "Error cancelling delete job:", err)
+		return
+	}
+	
+	respondWithDeleteJob(w, job, 200)
+}
+
+func getJobIdFromPath(r *http.Request) (int, error) {
+	id, err := strconv.Atoi(mux.Vars(r)[JobIdVar])
+	if err != nil {
+		return 0, redis.DeleteJobNotFoundError
+	}
+	return id, nil
+}
+
+// Returns the header decoded from the encoding given for key names, if any.
+func getDecodedHeader(r *http.Request, header string) (string, error) {
+	return redis.DecodeString(r.Header.Get(header), r.Header.Get(KeyEncodingHeader))
+}
+
+// Returns nil if the request has no filter parameters.
+func getKeyFilter(r *http.Request) (*dto.KeyFilter, error) {
+	query := r.URL.Query()
+	filter := &dto.KeyFilter{Type: query.Get(TypeParam), Ttl: query.Get(TtlParam)}
+	params := map[string]*int64{MinTtlParam: &filter.MinTtl,
+		MaxTtlParam: &filter.MaxTtl,
+		MinIdleParam: &filter.MinIdle}
+	for param, field := range params {
+		valStr := query.Get(param)
+		if valStr == "" {
+			continue
+		}
+		val, err := strconv.ParseInt(valStr, 10, 64)
+		if err != nil {
+			return nil, &redis.InvalidKeyFilterError{Message: "The " + param +
+				" parameter must be a whole number of seconds"}
+		}
+		*field = val
+	}
+	if *filter == (dto.KeyFilter{}) {
+		return nil, nil
+	}
+	return filter, nil
+}
+
+func respondWithDeleteJob(w http.ResponseWriter, job *dto.DeleteJob, statusCode int) {
+	jobResp := &dto.DeleteJobResponse{Job: job}
+	respBytes, err := jobResp.JsonBytes()
+	if err != nil {
+		processError(w, "Error marshalling delete job to json:", err)
+		return
+	}
+	
+	w.WriteHeader(statusCode)
+	w.Write(respBytes)
+}
+
+
+func (this *RedisServer) GetBackups(w http.ResponseWriter,
+		r *http.Request) {
+	defer recoverFromPanic(w, "GetBackups")
+	w.Header().Add("Content-Type", "application/json")
+	
+	backups, err := this.redisService.GetBackups()
+	if err != nil {
+		processError(w, "Error listing backups:", err)
+		return
+	}
+	
+	backupsResp := &dto.BackupsResponse{Backups: backups}
+	respBytes, err := backupsResp.JsonBytes()
+	if err != nil {
+		processError(w, "Error marshalling backups to json:", err)
+		return
+	}
+	
+	w.Write(respBytes)
+}
+
+
+func (this *RedisServer) DeleteBackup(w http.ResponseWriter,
+		r *http.Request) {
+	defer recoverFromPanic(w, "DeleteBackup")
+	w.Header().Add("Content-Type", "application/json")
+	
+	err := this.redisService.DeleteBackup(mux.Vars(r)[BackupIdVar])
+	if err != nil {
+		processError(w, "Error deleting backup:", err)
+		return
+	}
+	
+	returnBaseResponse(w)
+}
+
+
+func (this *RedisServer) RestoreBackup(w http.ResponseWriter,
+		r *http.Request) {
+	defer recoverFromPanic(w, "RestoreBackup")
+	w.Header().Add("Content-Type", "application/json")
+	
+	reader := r.Body
+	reqObj := new(dto.RestoreBackupRequest)
+	err := json.NewDecoder(reader).Decode(reqObj)
+	if err != nil {
+		processError(w, "Error decoding json:", err)
+		return
+	}
+	confirmToken := r.Header.Get(ConfirmTokenHeader)
+	
+	restoreResp, err := this.redisService.RestoreBackup(reqObj, confirmToken)
+	if err != nil {
+		processError(w, "Error restoring backup:", err)
+		return
+	}
+	
+	respBytes, err := restoreResp.JsonBytes()
+	if err != nil {
+		processError(w, "Error marshalling restore response to json:", err)
+		return
+	}
+	
 	w.Write(respBytes)
 }
 
@@ -236,10 +835,71 @@ func (this *RedisServer) Close() error {
 
 func processError(w http.ResponseWriter, logMessagePrefix string, err error) {
 	logger.Error(logMessagePrefix, err)
-	w.WriteHeader(500)
 	//message := "There was an error processing the request"
 	message := err.Error()
 	errResp := &dto.ErrorResponse{Message: message}
+	statusCode := 500
+	// ACL failures get their own status and code so the UI can tell them apart
+	if aclErr, isAclErr := err.(*redis.AclError); isAclErr {
+		errResp.Code = aclErr.Code
+		switch aclErr.Code {
+		case redis.AclCodeNoPerm : statusCode = 403
+		default : statusCode = 401
+		}
+	}
+	if validationErr, isValidationErr := err.(*rconns.ValidationError); isValidationErr {
+		errResp.Code = InvalidConnectionCode
+		errResp.Fields = validationErr.Fields
+		statusCode = 400
+	}
+	if _, isFilterErr := err.(*redis.InvalidKeyFilterError); isFilterErr {
+		statusCode = 400
+	}
+	if groupErr, isGroupErr := err.(*redis.StreamGroupError); isGroupErr {
+		statusCode = 404
+		if groupErr.Exists {
+			statusCode = 409
+		}
+	}
+	if confirmErr, isConfirmErr := err.(*redis.ConfirmationRequiredError); isConfirmErr {
+		errResp.Code = ConfirmationRequiredCode
+		errResp.Confirmation = confirmErr.Confirmation
+		statusCode = 428
+	}
+	switch err {
+	case redis.ReadOnlyError :
+		errResp.Code = ReadOnlyCode
+		statusCode = 403
+	case rconns.StoreLockedError :
+		errResp.Code = StoreLockedCode
+		statusCode = 423
+	case rconns.WrongMasterPasswordError :
+		errResp.Code = WrongMasterPasswordCode
+		statusCode = 401
+	case rconns.ConnectionsVersionNotFoundError :
+		statusCode = 404
+	case rconns.UndecryptableVersionError :
+		statusCode = 409
+	case redis.DeleteJobNotFoundError, redis.BackupNotFoundError :
+		statusCode = 404
+	case redis.InvalidRestoreModeError :
+		statusCode = 400
+	case redis.InvalidEncodingError, redis.UndecodableStringError :
+		statusCode = 400
+	case redis.KeyNotFoundError :
+		statusCode = 404
+	case redis.NotCollectionError, redis.ListMatchError, redis.InvalidCursorError :
+		statusCode = 400
+	case redis.InvalidStreamRequestError, redis.MissingStreamConsumerError,
+			redis.MissingStreamIdError :
+		statusCode = 400
+	case rconns.InvalidSortError :
+		statusCode = 400
+	case UnauthorizedError :
+		errResp.Code = UnauthorizedCode
+		statusCode = 401
+	}
+	w.WriteHeader(statusCode)
 
 	respObj := &dto.BaseResponse{}
 	respObj.Error = errResp
@@ -250,6 +910,46 @@ func processError(w http.ResponseWriter, logMessagePrefix string, err error) {
 }
 
 
+// Responds to an edit based on an outdated revision with 409 and the
+// connections as they are now, so the client can merge and retry.
+func respondWithCurrentConnections(w http.ResponseWriter, logMessagePrefix string, err error) {
+	logger.Warning(logMessagePrefix, err)
+	conns, revision, readErr := rconns.ReadConnectionsWithRevision()
+	if readErr != nil {
+		processError(w, "Error reading connections:", readErr)
+		return
+	}
+	setRevisionHeader(w, revision)
+	connsResp := &dto.ConnectionsResponse{Connections: conns,
+		Locked: rconns.IsStoreLocked(),
+		Revision: revision}
+	connsResp.Error = &dto.ErrorResponse{Message: err.Error(), Code: StaleRevisionCode}
+	respBytes, marshalErr := connsResp.JsonBytes()
+	if marshalErr != nil {
+		processError(w, "Error marshalling connections list to json:", marshalErr)
+		return
+	}
+	w.WriteHeader(409)
+	w.Write(respBytes)
+}
+
+func setRevisionHeader(w http.ResponseWriter, revision string) {
+	w.Header().Add(ExposeHeadersHeader, ETagHeader)
+	w.Header().Set(ETagHeader, "\"" + revision + "\"")
+}
+
+// Returns the revision from the If-Match header, or blank if there's no
+// header or it matches any revision.
+func getExpectedRevision(r *http.Request) string {
+	ifMatch := strings.TrimSpace(r.Header.Get(IfMatchHeader))
+	if ifMatch == "*" {
+		return ""
+	}
+	ifMatch = strings.TrimPrefix(ifMatch, "W/")
+	return strings.Trim(ifMatch, "\"")
+}
+
+
 func returnBaseResponse(w http.ResponseWriter) {
 	respObj := &dto.BaseResponse{}
 	respBytes, err := respObj.JsonBytes()
@@ -0,0 +1,286 @@
+package server
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
+
+	"github.com/bencase/revis-service/dto"
+)
+
+// Select the consumer group and pending entries. The idle time is in
+// milliseconds as Redis takes it, unlike minIdle for pattern deletes.
+const GroupParam string = "group"
+const ConsumerParam string = "consumer"
+const StartParam string = "start"
+const EndParam string = "end"
+const MinIdleMsParam string = "minIdleMs"
+
+
+func (this *RedisServer) GetStreamGroups(w http.ResponseWriter,
+		r *http.Request) {
+	defer recoverFromPanic(w, "GetStreamGroups")
+	w.Header().Add("Content-Type", "application/json")
+
+	connName, key, err := getStreamHeaders(r)
+	if err != nil {
+		processError(w, "Error parsing header:", err)
+		return
+	}
+
+	groups, err := this.redisService.GetStreamGroups(connName, key)
+	if err != nil {
+		processError(w, "Error getting consumer groups:", err)
+		return
+	}
+
+	groupsResp := &dto.StreamGroupsResponse{Groups: groups}
+	respBytes, err := groupsResp.JsonBytes()
+	if err != nil {
+		processError(w, "Error marshalling consumer groups to json:", err)
+		return
+	}
+
+	w.Write(respBytes)
+}
+
+
+func (this *RedisServer) CreateStreamGroup(w http.ResponseWriter,
+		r *http.Request) {
+	defer recoverFromPanic(w, "CreateStreamGroup")
+	w.Header().Add("Content-Type", "application/json")
+
+	connName, key, err := getStreamHeaders(r)
+	if err != nil {
+		processError(w, "Error parsing header:", err)
+		return
+	}
+	reqObj := new(dto.StreamGroupRequest)
+	err = json.NewDecoder(r.Body).Decode(reqObj)
+	if err != nil {
+		processError(w, "Error decoding json:", err)
+		return
+	}
+	confirmToken := r.Header.Get(ConfirmTokenHeader)
+
+	err = this.redisService.CreateStreamGroup(connName, key, reqObj, confirmToken)
+	if err != nil {
+		processError(w, "Error creating consumer group:", err)
+		return
+	}
+
+	returnBaseResponse(w)
+}
+
+
+func (this *RedisServer) DestroyStreamGroup(w http.ResponseWriter,
+		r *http.Request) {
+	defer recoverFromPanic(w, "DestroyStreamGroup")
+	w.Header().Add("Content-Type", "application/json")
+
+	connName, key, err := getStreamHeaders(r)
+	if err != nil {
+		processError(w, "Error parsing header:", err)
+		return
+	}
+	group := r.URL.Query().Get(GroupParam)
+	confirmToken := r.Header.Get(ConfirmTokenHeader)
+
+	err = this.redisService.DestroyStreamGroup(connName, key, group, confirmToken)
+	if err != nil {
+		processError(w, "Error destroying consumer group:", err)
+		return
+	}
+
+	returnBaseResponse(w)
+}
+
+
+func (this *RedisServer) SetStreamGroupId(w http.ResponseWriter,
+		r *http.Request) {
+	defer recoverFromPanic(w, "SetStreamGroupId")
+	w.Header().Add("Content-Type", "application/json")
+
+	connName, key, err := getStreamHeaders(r)
+	if err != nil {
+		processError(w, "Error parsing header:", err)
+		return
+	}
+	reqObj := new(dto.StreamGroupRequest)
+	err = json.NewDecoder(r.Body).Decode(reqObj)
+	if err != nil {
+		processError(w, "Error decoding json:", err)
+		return
+	}
+	confirmToken := r.Header.Get(ConfirmTokenHeader)
+
+	err = this.redisService.SetStreamGroupId(connName, key, reqObj, confirmToken)
+	if err != nil {
+		processError(w, "Error setting consumer group ID:", err)
+		return
+	}
+
+	returnBaseResponse(w)
+}
+
+
+func (this *RedisServer) GetStreamConsumers(w http.ResponseWriter,
+		r *http.Request) {
+	defer recoverFromPanic(w, "GetStreamConsumers")
+	w.Header().Add("Content-Type", "application/json")
+
+	connName, key, err := getStreamHeaders(r)
+	if err != nil {
+		processError(w, "Error parsing header:", err)
+		return
+	}
+	group := r.URL.Query().Get(GroupParam)
+
+	consumers, err := this.redisService.GetStreamConsumers(connName, key, group)
+	if err != nil {
+		processError(w, "Error getting consumers:", err)
+		return
+	}
+
+	consumersResp := &dto.StreamConsumersResponse{Consumers: consumers}
+	respBytes, err := consumersResp.JsonBytes()
+	if err != nil {
+		processError(w, "Error marshalling consumers to json:", err)
+		return
+	}
+
+	w.Write(respBytes)
+}
+
+
+func (this *RedisServer) GetPendingStreamEntries(w http.ResponseWriter,
+		r *http.Request) {
+	defer recoverFromPanic(w, "GetPendingStreamEntries")
+	w.Header().Add("Content-Type", "application/json")
+
+	connName, key, err := getStreamHeaders(r)
+	if err != nil {
+		processError(w, "Error parsing header:", err)
+		return
+	}
+	query := r.URL.Query()
+	reqObj := &dto.PendingEntriesRequest{Group: query.Get(GroupParam),
+		Consumer: query.Get(ConsumerParam),
+		Start: query.Get(StartParam),
+		End: query.Get(EndParam)}
+	if minIdleMs := query.Get(MinIdleMsParam); minIdleMs != "" {
+		reqObj.MinIdleMs, err = strconv.ParseInt(minIdleMs, 10, 64)
+		if err != nil {
+			processError(w, "Error parsing minimum idle time:", err)
+			return
+		}
+	}
+	if count := query.Get(CountParam); count != "" {
+		reqObj.Count, err = strconv.Atoi(count)
+		if err != nil {
+			processError(w, "Error parsing count:", err)
+			return
+		}
+	}
+
+	entries, err := this.redisService.GetPendingStreamEntries(connName, key, reqObj)
+	if err != nil {
+		processError(w, "Error getting pending entries:", err)
+		return
+	}
+
+	entriesResp := &dto.PendingEntriesResponse{Entries: entries}
+	respBytes, err := entriesResp.JsonBytes()
+	if err != nil {
+		processError(w, "Error marshalling pending entries to json:", err)
+		return
+	}
+
+	w.Write(respBytes)
+}
+
+
+func (this *RedisServer) ClaimStreamEntries(w http.ResponseWriter,
+		r *http.Request) {
+	defer recoverFromPanic(w, "ClaimStreamEntries")
+	w.Header().Add("Content-Type", "application/json")
+
+	connName, key, err := getStreamHeaders(r)
+	if err != nil {
+		processError(w, "Error parsing header:", err)
+		return
+	}
+	reqObj := new(dto.ClaimStreamEntriesRequest)
+	err = json.NewDecoder(r.Body).Decode(reqObj)
+	if err != nil {
+		processError(w, "Error decoding json:", err)
+		return
+	}
+	confirmToken := r.Header.Get(ConfirmTokenHeader)
+
+	claimed, err := this.redisService.ClaimStreamEntries(connName, key, reqObj, confirmToken)
+	if err != nil {
+		processError(w, "Error claiming pending entries:", err)
+		return
+	}
+
+	claimResp := &dto.ClaimStreamEntriesResponse{ClaimedStreamEntries: *claimed}
+	respBytes, err := claimResp.JsonBytes()
+	if err != nil {
+		processError(w, "Error marshalling claimed entries to json:", err)
+		return
+	}
+
+	w.Write(respBytes)
+}
+
+
+func (this *RedisServer) AckStreamEntries(w http.ResponseWriter,
+		r *http.Request) {
+	defer recoverFromPanic(w, "AckStreamEntries")
+	w.Header().Add("Content-Type", "application/json")
+
+	connName, key, err := getStreamHeaders(r)
+	if err != nil {
+		processError(w, "Error parsing header:", err)
+		return
+	}
+	reqObj := new(dto.AckStreamEntriesRequest)
+	err = json.NewDecoder(r.Body).Decode(reqObj)
+	if err != nil {
+		processError(w, "Error decoding json:", err)
+		return
+	}
+	confirmToken := r.Header.Get(ConfirmTokenHeader)
+
+	acked, err := this.redisService.AckStreamEntries(connName, key, reqObj, confirmToken)
+	if err != nil {
+		processError(w, "Error acknowledging entries:", err)
+		return
+	}
+
+	ackResp := &dto.AckStreamEntriesResponse{Acked: acked}
+	respBytes, err := ackResp.JsonBytes()
+	if err != nil {
+		processError(w, "Error marshalling acknowledged count to json:", err)
+		return
+	}
+
+	w.Write(respBytes)
+}
+
+
+// Returns the connection name and stream key headers.
+func getStreamHeaders(r *http.Request) (string, string, error) {
+	connName := r.Header.Get(ConnNameHeader)
+	if connName == "" {
+		return "", "", errors.New("Header does not contain connection name")
+	}
+	key, err := getDecodedHeader(r, KeyHeader)
+	if err != nil { return "", "", err }
+	if key == "" {
+		return "", "", errors.New("Header does not contain stream key")
+	}
+	return connName, key, nil
+}
\ No newline at end of file
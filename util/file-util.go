@@ -0,0 +1,62 @@
+package util
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+)
+
+// Writes the file by way of a temporary file in the same directory which is
+// synced and then renamed over the original, so that a crash leaves either
+// the old or the new contents but never a partial file.
+func WriteFileAtomic(path string, data []byte, perm os.FileMode) error {
+	dir := filepath.Dir(path)
+	tmpFile, err := ioutil.TempFile(dir, "." + filepath.Base(path) + ".tmp")
+	if err != nil { return err }
+	tmpPath := tmpFile.Name()
+	_, err = tmpFile.Write(data)
+	if err == nil {
+		err = tmpFile.Chmod(perm)
+	}
+	if err == nil {
+		err = tmpFile.Sync()
+	}
+	if closeErr := tmpFile.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(tmpPath)
+		return err
+	}
+	err = os.Rename(tmpPath, path)
+	if err != nil {
+		os.Remove(tmpPath)
+		return err
+	}
+	syncDir(dir)
+	return nil
+}
+
+// Syncs a directory so that a rename within it is durable. Not every platform
+// supports this, so failures are ignored.
+func syncDir(dir string) {
+	dirFile, err := os.Open(dir)
+	if err != nil { return }
+	dirFile.Sync()
+	dirFile.Close()
+}
+
+// Copies the file atomically. Returns false without error if the source doesn't exist.
+func CopyFileAtomic(srcPath string, dstPath string, perm os.FileMode) (bool, error) {
+	data, err := ioutil.ReadFile(srcPath)
+	if os.IsNotExist(err) {
+		return false, nil
+	}
+	if err != nil { return false, err }
+	return true, WriteFileAtomic(dstPath, data, perm)
+}
+
+func FileExists(path string) bool {
+	_, err := os.Stat(path)
+	return err == nil
+}
\ No newline at end of file
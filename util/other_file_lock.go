@@ -0,0 +1,23 @@
+// +build !darwin,!dragonfly,!freebsd,!linux,!netbsd,!openbsd,!windows
+
+package util
+
+import (
+	"os"
+)
+
+// Other systems have no advisory file locking, so the file is only opened
+// and writers are serialized by the caller's in-process lock alone.
+type FileLock struct {
+	file *os.File
+}
+
+func LockFile(path string) (*FileLock, error) {
+	file, err := os.OpenFile(path, os.O_CREATE|os.O_RDWR, 0600)
+	if err != nil { return nil, err }
+	return &FileLock{file: file}, nil
+}
+
+func (this *FileLock) Unlock() error {
+	return this.file.Close()
+}
\ No newline at end of file
@@ -0,0 +1,32 @@
+// +build darwin dragonfly freebsd linux netbsd openbsd
+
+package util
+
+import (
+	"os"
+	"syscall"
+)
+
+// An advisory lock held on a file, shared with other processes that lock the same path.
+type FileLock struct {
+	file *os.File
+}
+
+// Blocks until an exclusive lock on the file is acquired, creating the file if needed.
+func LockFile(path string) (*FileLock, error) {
+	file, err := os.OpenFile(path, os.O_CREATE|os.O_RDWR, 0600)
+	if err != nil { return nil, err }
+	err = syscall.Flock(int(file.Fd()), syscall.LOCK_EX)
+	if err != nil {
+		file.Close()
+		return nil, err
+	}
+	return &FileLock{file: file}, nil
+}
+
+func (this *FileLock) Unlock() error {
+	err := syscall.Flock(int(this.file.Fd()), syscall.LOCK_UN)
+	closeErr := this.file.Close()
+	if err != nil { return err }
+	return closeErr
+}
\ No newline at end of file
@@ -0,0 +1,41 @@
+// +build windows
+
+package util
+
+import (
+	"os"
+	"syscall"
+	"time"
+)
+
+const errorSharingViolation syscall.Errno = 32
+const lockRetryInterval = 50 * time.Millisecond
+
+// An advisory lock held on a file, shared with other processes that lock the same path.
+type FileLock struct {
+	file *os.File
+}
+
+// Blocks until an exclusive lock on the file is acquired, creating the file if
+// needed. The file is opened without sharing, so other processes can't open it
+// until it's unlocked.
+func LockFile(path string) (*FileLock, error) {
+	pathPtr, err := syscall.UTF16PtrFromString(path)
+	if err != nil { return nil, err }
+	for {
+		handle, err := syscall.CreateFile(pathPtr,
+			syscall.GENERIC_READ|syscall.GENERIC_WRITE,
+			0, nil, syscall.OPEN_ALWAYS, syscall.FILE_ATTRIBUTE_NORMAL, 0)
+		if err == nil {
+			return &FileLock{file: os.NewFile(uintptr(handle), path)}, nil
+		}
+		if err != errorSharingViolation {
+			return nil, err
+		}
+		time.Sleep(lockRetryInterval)
+	}
+}
+
+func (this *FileLock) Unlock() error {
+	return this.file.Close()
+}
\ No newline at end of file